@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexmullins/zip"
+)
+
+// Characters used for generated archive passwords
+const passwordChars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GeneratePassword returns a random password of the given length
+func GeneratePassword(length int) (string, error) {
+	max := big.NewInt(int64(len(passwordChars)))
+	pw := make([]byte, length)
+	for i := range pw {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		pw[i] = passwordChars[n.Int64()]
+	}
+	return string(pw), nil
+}
+
+// CreateArchives packs the files of each subject into one AES-256 encrypted
+// zip archive in tmpdir. The archives are returned in place of the files.
+func CreateArchives(files []FileData, format, password, tmpdir string) ([]FileData, error) {
+	format = strings.ToLower(format)
+	if format != "zip" {
+		return nil, fmt.Errorf("Unsupported archive format '%s'", format)
+	}
+
+	subjects, bySubject := groupBySubject(files)
+	archives := make([]FileData, 0, len(subjects))
+	for sn, subject := range subjects {
+		// Each subject gets its own directory so archive names can't collide
+		dir := filepath.Join(tmpdir, "archive", fmt.Sprintf("%d", sn))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+
+		name := SafeFileName(subject)
+		if len(name) == 0 {
+			name = "archive"
+		}
+		name += "." + format
+		path := filepath.Join(dir, name)
+
+		log.Info("Creating %s archive %s with %d file(s)", format, name, len(bySubject[subject]))
+		size, err := writeZip(path, files, bySubject[subject], password)
+		if err != nil {
+			return nil, err
+		}
+		sources := make([]string, 0, len(bySubject[subject]))
+		for _, i := range bySubject[subject] {
+			sources = append(sources, files[i].name)
+		}
+		archives = append(archives, FileData{path: path, name: name, size: size, subject: subject, input: files[bySubject[subject][0]].input, sources: sources})
+	}
+
+	return archives, nil
+}
+
+// writeZip stores the selected files in an encrypted zip and returns its size
+func writeZip(path string, files []FileData, indexes []int, password string) (int64, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	for _, i := range indexes {
+		fd := files[i]
+
+		// Most things we post are already compressed, so just store them
+		fh := &zip.FileHeader{Name: fd.name, Method: zip.Store}
+		fh.SetPassword(password)
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			return 0, err
+		}
+
+		in, err := os.Open(fd.path)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(w, io.NewSectionReader(in, fd.offset, fd.size))
+		in.Close()
+		if err != nil {
+			return 0, fmt.Errorf("archiving %s: %s", fd.path, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	st, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
@@ -27,6 +27,7 @@ type ArticleData struct {
 	FileTotal int
 	FileSize  int64
 	FileName  string
+	Groups    string
 }
 
 func NewArticle(p []byte, data *ArticleData, subject string) *Article {
@@ -37,22 +38,18 @@ func NewArticle(p []byte, data *ArticleData, subject string) *Article {
 		from = Config.Global.From
 	}
 
-	buf := new(bytes.Buffer)
-	buf.WriteString(fmt.Sprintf("From: %s\r\n", from))
-
-	var groups string
-	if len(*groupFlag) > 0 {
-		groups = *groupFlag
-	} else {
-		groups = Config.Global.DefaultGroup
+	groups := data.Groups
+	if len(groups) == 0 {
+		if len(*groupFlag) > 0 {
+			groups = *groupFlag
+		} else {
+			groups = Config.Global.DefaultGroup
+		}
 	}
-	buf.WriteString(fmt.Sprintf("Newsgroups: %s\r\n", groups))
 
 	var msgid string
 	t := time.Now()
 	msgid = fmt.Sprintf("%.5f$gps@%s", float64(t.UnixNano())/1.0e9, *hostFlag)
-	buf.WriteString(fmt.Sprintf("Message-ID: <%s>\r\n", msgid))
-	buf.WriteString(fmt.Sprintf("X-Newsposter: KereMagicPoster\r\n"))
 
 	// Build subject
 	// spec: c1 [fnum/ftotal] - "filename" yEnc (pnum/ptotal)
@@ -65,11 +62,19 @@ func NewArticle(p []byte, data *ArticleData, subject string) *Article {
 		subj = subject
 	}
 
-	subj = fmt.Sprintf("%s [%d/%d] - \"%s\" yEnc (%d/%d)", subj, data.FileNum, data.FileTotal, data.FileName, data.PartNum, data.PartTotal)
-	buf.WriteString(fmt.Sprintf("Subject: %s\r\n\r\n", subj))
+	// Quotes are reserved for the file name
+	subjName, yencName := articleFileNames(data.FileName, Config.Global.FileNames)
+	subj = strings.Replace(cleanSubject(subj, Config.Global.FileNames), `"`, "'", -1)
+	subj = fmt.Sprintf("%s [%d/%d] - \"%s\" yEnc (%d/%d)", subj, data.FileNum, data.FileTotal, subjName, data.PartNum, data.PartTotal)
+
+	buf := new(bytes.Buffer)
+	for _, h := range BuildHeaders(from, groups, msgid, subj, t) {
+		buf.WriteString(h.String())
+	}
+	buf.WriteString("\r\n")
 
 	// yEnc begin line
-	buf.WriteString(fmt.Sprintf("=ybegin part=%d total=%d line=128 size=%d name=%s\r\n", data.PartNum, data.PartTotal, data.FileSize, data.FileName))
+	buf.WriteString(fmt.Sprintf("=ybegin part=%d total=%d line=128 size=%d name=%s\r\n", data.PartNum, data.PartTotal, data.FileSize, yencName))
 	// yEnc part line
 	buf.WriteString(fmt.Sprintf("=ypart begin=%d end=%d\r\n", data.PartBegin+1, data.PartEnd))
 
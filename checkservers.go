@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+)
+
+// ServerCheck is the result of checking a single server
+type ServerCheck struct {
+	Connect        time.Duration
+	Auth           time.Duration
+	Latency        time.Duration
+	Greeting       string
+	PostingAllowed bool
+	TLS            bool
+	Caps           *simplenntp.Capabilities
+}
+
+// CheckServers connects to every server, logs in and reports what it
+// supports and how long that took. It returns false if any server failed.
+func CheckServers(servers map[string]*ConfigServer) bool {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ok := true
+	for _, name := range names {
+		sc, err := checkServer(servers[name])
+		if err != nil {
+			log.Error("[%s] FAILED: %s", name, err)
+			ok = false
+			continue
+		}
+		sc.Log(name)
+	}
+	return ok
+}
+
+func checkServer(server *ConfigServer) (*ServerCheck, error) {
+	sc := &ServerCheck{}
+
+	ctx, cancel := connectContext(server)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialServer(ctx, server, 1, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error while connecting: %s", err)
+	}
+	// Runs before cancel
+	defer conn.QuitContext(ctx)
+	sc.Connect = time.Since(start)
+	sc.Greeting = conn.Greeting
+	sc.PostingAllowed = conn.PostingAllowed
+	sc.TLS = conn.TLS
+
+	start = time.Now()
+	if err := authenticate(ctx, conn, server); err != nil {
+		return nil, fmt.Errorf("Error while authenticating: %s", err)
+	}
+	sc.Auth = time.Since(start)
+
+	start = time.Now()
+	caps, err := conn.CapabilitiesContext(ctx)
+	sc.Latency = time.Since(start)
+	if err != nil {
+		// Plenty of older servers don't do CAPABILITIES at all
+		if _, ok := err.(simplenntp.Error); !ok {
+			return nil, fmt.Errorf("Error while reading capabilities: %s", err)
+		}
+	} else {
+		sc.Caps = caps
+		if caps.Post {
+			sc.PostingAllowed = true
+		}
+	}
+	return sc, nil
+}
+
+// Log writes the results to the console
+func (sc *ServerCheck) Log(name string) {
+	log.Info("[%s] OK: connect %s, login %s, latency %s", name,
+		sc.Connect.Round(time.Millisecond), sc.Auth.Round(time.Millisecond), sc.Latency.Round(time.Millisecond))
+	log.Info("[%s]   Greeting: %s", name, sc.Greeting)
+	log.Info("[%s]   Encrypted: %s", name, yesNo(sc.TLS))
+	log.Info("[%s]   Posting allowed: %s", name, yesNo(sc.PostingAllowed))
+	if sc.Caps == nil {
+		log.Info("[%s]   Capabilities: not supported", name)
+		return
+	}
+
+	labels := make([]string, 0, len(sc.Caps.Raw))
+	for label := range sc.Caps.Raw {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	log.Info("[%s]   Capabilities: %s", name, strings.Join(labels, " "))
+	log.Info("[%s]   POST: %s, IHAVE: %s, STREAMING: %s, STARTTLS: %s", name,
+		yesNo(sc.Caps.Post), yesNo(sc.Caps.IHave), yesNo(sc.Caps.Streaming), yesNo(sc.Caps.StartTLS))
+	log.Info("[%s]   COMPRESS: %s, AUTHINFO: %s, SASL: %s", name,
+		noneIfEmpty(sc.Caps.Compress), noneIfEmpty(sc.Caps.AuthInfo), noneIfEmpty(sc.Caps.SASL))
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func noneIfEmpty(s []string) string {
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, " ")
+}
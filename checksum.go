@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Supported checksum manifest types, in the order they are posted
+var checksumTypes = []string{"sfv", "md5", "sha256"}
+
+type fileSums struct {
+	crc32  uint32
+	md5    []byte
+	sha256 []byte
+}
+
+// ParseChecksums turns a comma separated list like "sfv,sha256" into a
+// de-duplicated list of manifest types.
+func ParseChecksums(str string) ([]string, error) {
+	wanted := make(map[string]bool)
+	for _, t := range strings.Split(str, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if len(t) == 0 {
+			continue
+		}
+		if t == "sha-256" {
+			t = "sha256"
+		}
+		known := false
+		for _, ct := range checksumTypes {
+			if t == ct {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown checksum type '%s'", t)
+		}
+		wanted[t] = true
+	}
+
+	types := make([]string, 0, len(wanted))
+	for _, ct := range checksumTypes {
+		if wanted[ct] {
+			types = append(types, ct)
+		}
+	}
+	return types, nil
+}
+
+// hashFile reads a file (or volume) once and calculates every requested checksum
+func hashFile(fd FileData, types []string) (*fileSums, error) {
+	file, err := os.Open(fd.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var hc, hm, hs hash.Hash
+	writers := make([]io.Writer, 0, len(types))
+	for _, t := range types {
+		switch t {
+		case "sfv":
+			hc = crc32.NewIEEE()
+			writers = append(writers, hc)
+		case "md5":
+			hm = md5.New()
+			writers = append(writers, hm)
+		case "sha256":
+			hs = sha256.New()
+			writers = append(writers, hs)
+		}
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), io.NewSectionReader(file, fd.offset, fd.size)); err != nil {
+		return nil, err
+	}
+
+	fs := &fileSums{}
+	if hc != nil {
+		fs.crc32 = hc.(hash.Hash32).Sum32()
+	}
+	if hm != nil {
+		fs.md5 = hm.Sum(nil)
+	}
+	if hs != nil {
+		fs.sha256 = hs.Sum(nil)
+	}
+	return fs, nil
+}
+
+// hashFiles hashes all files using one worker per CPU
+func hashFiles(files []FileData, types []string) ([]*fileSums, error) {
+	sums := make([]*fileSums, len(files))
+	errs := make([]error, len(files))
+
+	jobs := make(chan int, len(files))
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+
+	workers := runtime.NumCPU()
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sums[i], errs[i] = hashFile(files[i], types)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("hashing %s: %s", files[i].path, err)
+		}
+	}
+	return sums, nil
+}
+
+// CreateChecksums hashes files and writes one manifest of each requested type
+// per subject into tmpdir. The manifests are returned as extra files to post
+// with the same subject as the files they describe.
+func CreateChecksums(files []FileData, types []string, tmpdir string) ([]FileData, error) {
+	if len(files) == 0 || len(types) == 0 {
+		return nil, nil
+	}
+
+	log.Info("Calculating %s checksums for %d file(s)", strings.Join(types, "/"), len(files))
+	sums, err := hashFiles(files, types)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects, bySubject := groupBySubject(files)
+
+	manifests := make([]FileData, 0, len(subjects)*len(types))
+	for sn, subject := range subjects {
+		// Each subject gets its own directory so manifest names can't collide
+		dir := filepath.Join(tmpdir, "checksums", fmt.Sprintf("%d", sn))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+
+		name := SafeFileName(subject)
+		if len(name) == 0 {
+			name = "checksums"
+		}
+
+		for _, t := range types {
+			var sb strings.Builder
+			if t == "sfv" {
+				sb.WriteString("; Generated by GoPostStuff " + GPS_VERSION + "\r\n")
+			}
+			for _, i := range bySubject[subject] {
+				base := files[i].name
+				switch t {
+				case "sfv":
+					sb.WriteString(fmt.Sprintf("%s %08X\r\n", base, sums[i].crc32))
+				case "md5":
+					sb.WriteString(fmt.Sprintf("%s *%s\n", hex.EncodeToString(sums[i].md5), base))
+				case "sha256":
+					sb.WriteString(fmt.Sprintf("%s *%s\n", hex.EncodeToString(sums[i].sha256), base))
+				}
+			}
+
+			path := filepath.Join(dir, name+"."+t)
+			if err := ioutil.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, FileData{path: path, name: name + "." + t, size: int64(sb.Len()), subject: subject, input: files[bySubject[subject][0]].input})
+			log.Debug("Created checksum manifest %s", path)
+		}
+	}
+
+	return manifests, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksums(t *testing.T) {
+	tests := []struct {
+		str   string
+		types []string
+	}{
+		{"", []string{}},
+		{"sfv", []string{"sfv"}},
+		{" SHA-256 , sfv,,sfv", []string{"sfv", "sha256"}},
+		{"sha256,md5,sfv", []string{"sfv", "md5", "sha256"}},
+	}
+	for _, test := range tests {
+		types, err := ParseChecksums(test.str)
+		if err != nil {
+			t.Errorf("%q: %s", test.str, err)
+		} else if !reflect.DeepEqual(types, test.types) {
+			t.Errorf("%q: got %v, want %v", test.str, types, test.types)
+		}
+	}
+
+	if _, err := ParseChecksums("sfv,crc64"); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+func TestCreateChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gps-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(path, []byte("xxhello worldyy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the byte range of each file counts
+	files := []FileData{
+		{path: path, name: "hello.txt", offset: 2, size: 11, subject: "First", input: dir},
+		{path: path, name: "x.txt", offset: 0, size: 1, subject: "Second", input: dir},
+	}
+	manifests, err := CreateChecksums(files, []string{"sfv", "md5", "sha256"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 6 {
+		t.Fatalf("expected 6 manifests, got %d", len(manifests))
+	}
+
+	want := map[string]string{
+		"first.sfv":     "hello.txt 0D4A1185\r\n",
+		"first.md5":     "5eb63bbbe01eeed093cb22bb8f5acdc3 *hello.txt\n",
+		"first.sha256":  "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9 *hello.txt\n",
+		"second.sfv":    "x.txt 8CDC1683\r\n",
+		"second.md5":    "9dd4e461268c8034f5c8564e155c67a6 *x.txt\n",
+		"second.sha256": "2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881 *x.txt\n",
+	}
+	for _, m := range manifests {
+		data, err := ioutil.ReadFile(m.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.size != int64(len(data)) || m.input != dir {
+			t.Errorf("%s: unexpected size %d or input %s", m.name, m.size, m.input)
+		}
+		if m.subject != files[0].subject && m.subject != files[1].subject {
+			t.Errorf("%s: unexpected subject %s", m.name, m.subject)
+		}
+		content := string(data)
+		if strings.HasSuffix(m.name, ".sfv") {
+			if !strings.HasPrefix(content, "; Generated by GoPostStuff") {
+				t.Errorf("%s: missing comment line", m.name)
+			}
+			content = content[strings.Index(content, "\n")+1:]
+		}
+		if content != want[m.name] {
+			t.Errorf("%s: got %q, want %q", m.name, content, want[m.name])
+		}
+	}
+
+	if manifests, err := CreateChecksums(files, nil, dir); err != nil || manifests != nil {
+		t.Errorf("expected no manifests without types, got %v, %v", manifests, err)
+	}
+}
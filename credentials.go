@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+)
+
+type credentials struct {
+	username string
+	password string
+	err      error
+}
+
+// Credentials are looked up once per server, PasswordCommand may be slow or
+// even ask for a passphrase.
+var (
+	credentialCache = make(map[*ConfigServer]*credentials)
+	credentialLock  sync.Mutex
+)
+
+// Credentials returns the username and password for the server. Values in
+// the config win, then UsernameEnv/PasswordEnv, PasswordCommand and finally
+// the NetrcFile entry for the server's address.
+func (s *ConfigServer) Credentials() (string, string, error) {
+	credentialLock.Lock()
+	defer credentialLock.Unlock()
+
+	c, ok := credentialCache[s]
+	if !ok {
+		c = &credentials{}
+		c.username, c.password, c.err = s.lookupCredentials()
+		credentialCache[s] = c
+	}
+	return c.username, c.password, c.err
+}
+
+func (s *ConfigServer) lookupCredentials() (string, string, error) {
+	username, password := s.Username, s.Password
+
+	if len(username) == 0 && len(s.UsernameEnv) > 0 {
+		username = os.Getenv(s.UsernameEnv)
+	}
+	if len(password) == 0 && len(s.PasswordEnv) > 0 {
+		password = os.Getenv(s.PasswordEnv)
+	}
+
+	if len(password) == 0 && len(s.PasswordCommand) > 0 {
+		cmd := exec.Command("/bin/sh", "-c", s.PasswordCommand)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", "", fmt.Errorf("PasswordCommand failed: %s", err)
+		}
+		// Only the first line, password managers like to add more
+		password = strings.TrimRight(strings.SplitN(string(out), "\n", 2)[0], "\r")
+	}
+
+	if (len(username) == 0 || len(password) == 0) && len(s.NetrcFile) > 0 {
+		f, err := os.Open(expandHome(s.NetrcFile))
+		if err != nil {
+			return "", "", err
+		}
+		defer f.Close()
+		login, pass, err := parseNetrc(f, s.Address)
+		if err != nil {
+			return "", "", fmt.Errorf("%s: %s", s.NetrcFile, err)
+		}
+		if len(username) == 0 {
+			username = login
+		}
+		if len(password) == 0 && (login == username || len(login) == 0) {
+			password = pass
+		}
+	}
+
+	// A username on its own is fine, unless a password was looked for
+	lookedUp := len(s.PasswordEnv) > 0 || len(s.PasswordCommand) > 0 || len(s.NetrcFile) > 0
+	if len(username) > 0 && len(password) == 0 && lookedUp {
+		return "", "", fmt.Errorf("No password found for user '%s'", username)
+	}
+	return username, password, nil
+}
+
+// parseNetrc returns the login and password for machine from a netrc file,
+// falling back to the default entry.
+func parseNetrc(r io.Reader, machine string) (string, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Skip macdef bodies, they run until an empty line
+	var tokens []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = len(strings.TrimSpace(line)) > 0
+			continue
+		}
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if f == "macdef" {
+				inMacro = true
+				fields = fields[:i]
+				break
+			}
+		}
+		tokens = append(tokens, fields...)
+	}
+
+	type entry struct{ login, password string }
+	var found, fallback *entry
+	var current *entry
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if i+1 >= len(tokens) {
+				return "", "", fmt.Errorf("machine without a name")
+			}
+			i++
+			current = &entry{}
+			if tokens[i] == machine && found == nil {
+				found = current
+			}
+		case "default":
+			current = &entry{}
+			if fallback == nil {
+				fallback = current
+			}
+		case "login", "password", "account":
+			if i+1 >= len(tokens) {
+				return "", "", fmt.Errorf("%s without a value", tokens[i])
+			}
+			i++
+			if current == nil {
+				return "", "", fmt.Errorf("%s outside of a machine entry", tokens[i-1])
+			}
+			if tokens[i-1] == "login" {
+				current.login = tokens[i]
+			} else if tokens[i-1] == "password" {
+				current.password = tokens[i]
+			}
+		default:
+			return "", "", fmt.Errorf("unknown token '%s'", tokens[i])
+		}
+	}
+
+	if found == nil {
+		found = fallback
+	}
+	if found == nil {
+		return "", "", nil
+	}
+	return found.login, found.password, nil
+}
+
+// expandHome replaces a leading ~/ with the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	u, err := user.Current()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(u.HomeDir, path[2:])
+}
+
+// authenticate logs in to server on conn with the configured method, if
+// there is a username at all. It gives up once ctx is done.
+func authenticate(ctx context.Context, conn *simplenntp.Conn, server *ConfigServer) error {
+	username, password, err := server.Credentials()
+	if err != nil || len(username) == 0 {
+		return err
+	}
+
+	method := strings.ToLower(server.AuthMethod)
+	if method == "auto" {
+		// Always ask again, what the server offers changes after STARTTLS
+		method = "user"
+		caps, err := conn.CapabilitiesContext(ctx)
+		if err != nil {
+			// Servers that don't know CAPABILITIES don't know SASL either
+			e, ok := err.(simplenntp.Error)
+			if !ok || (e.Code != simplenntp.StatusUnknownCommand && e.Code != simplenntp.StatusPermissionDenied) {
+				return fmt.Errorf("Unable to read capabilities for AuthMethod=auto: %s", err)
+			}
+			log.Debug("Server doesn't report capabilities, using AUTHINFO USER")
+		} else if contains(caps.AuthInfo, "SASL") && contains(caps.SASL, "PLAIN") {
+			method = "sasl"
+		}
+	}
+
+	if method == "sasl" {
+		return conn.AuthenticateSASLContext(ctx, username, password)
+	}
+	return conn.AuthenticateContext(ctx, username, password)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+)
+
+const testNetrc = `# usenet
+machine news.example.com login alice password s3cret
+machine other.example.com
+	login bob
+	password hunter2
+macdef init
+	cd /pub
+	bin
+
+default login anon password guest
+`
+
+func TestParseNetrc(t *testing.T) {
+	for machine, expected := range map[string][2]string{
+		"news.example.com":  {"alice", "s3cret"},
+		"other.example.com": {"bob", "hunter2"},
+		"unknown.example":   {"anon", "guest"},
+	} {
+		login, password, err := parseNetrc(strings.NewReader(testNetrc), machine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login != expected[0] || password != expected[1] {
+			t.Errorf("%s: expected %v, got %s/%s", machine, expected, login, password)
+		}
+	}
+
+	if _, _, err := parseNetrc(strings.NewReader("machine x login"), "x"); err == nil {
+		t.Errorf("expected an error for a truncated file")
+	}
+	if _, _, err := parseNetrc(strings.NewReader("password nobody"), "x"); err == nil {
+		t.Errorf("expected an error for a password outside of an entry")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gps-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	netrc := filepath.Join(dir, "netrc")
+	if err := ioutil.WriteFile(netrc, []byte(testNetrc), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GPS_TEST_USER", "envuser")
+	os.Setenv("GPS_TEST_PASS", "envpass")
+	defer os.Unsetenv("GPS_TEST_USER")
+	defer os.Unsetenv("GPS_TEST_PASS")
+
+	tests := []struct {
+		name     string
+		server   ConfigServer
+		username string
+		password string
+	}{
+		{"config", ConfigServer{Username: "u", Password: "p", PasswordEnv: "GPS_TEST_PASS"}, "u", "p"},
+		{"env", ConfigServer{UsernameEnv: "GPS_TEST_USER", PasswordEnv: "GPS_TEST_PASS"}, "envuser", "envpass"},
+		{"command", ConfigServer{Username: "u", PasswordCommand: "printf 'fromcmd\\nmetadata\\n'"}, "u", "fromcmd"},
+		{"netrc", ConfigServer{Address: "news.example.com", NetrcFile: netrc}, "alice", "s3cret"},
+		{"nothing", ConfigServer{Address: "news.example.com"}, "", ""},
+		{"username only", ConfigServer{Username: "u"}, "u", ""},
+	}
+	for _, test := range tests {
+		server := test.server
+		username, password, err := server.Credentials()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if username != test.username || password != test.password {
+			t.Errorf("%s: expected %s/%s, got %s/%s", test.name, test.username, test.password, username, password)
+		}
+	}
+
+	server := ConfigServer{Username: "u", PasswordCommand: "exit 1"}
+	if _, _, err := server.Credentials(); err == nil {
+		t.Errorf("expected an error for a failing PasswordCommand")
+	}
+	// The netrc entry is for alice, so there's no password for carol
+	server = ConfigServer{Address: "news.example.com", Username: "carol", NetrcFile: netrc}
+	if _, _, err := server.Credentials(); err == nil {
+		t.Errorf("expected an error for a username without a password")
+	}
+}
+
+// authServer answers every connection with the reply for each command it
+// knows, or 500, and records the commands it got.
+func authServer(t *testing.T, replies map[string]string) (net.Listener, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := make(chan string, 100)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				c.Write([]byte("200 hello\r\n"))
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimRight(line, "\r\n")
+					commands <- line
+					reply, ok := replies[line]
+					if !ok {
+						reply = "500 what?\r\n"
+					}
+					c.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+	return l, commands
+}
+
+func TestAuthenticateAuto(t *testing.T) {
+	ok := map[string]string{
+		"AUTHINFO USER u":              "381 more\r\n",
+		"AUTHINFO PASS p":              "281 ok\r\n",
+		"AUTHINFO SASL PLAIN AHUAcA==": "281 ok\r\n",
+	}
+	tests := []struct {
+		name  string
+		caps  string
+		first string
+		ok    bool
+	}{
+		{"sasl", "101 caps\r\nVERSION 2\r\nAUTHINFO USER SASL\r\nSASL PLAIN\r\n.\r\n", "AUTHINFO SASL PLAIN AHUAcA==", true},
+		{"user", "101 caps\r\nVERSION 2\r\nAUTHINFO USER\r\n.\r\n", "AUTHINFO USER u", true},
+		{"no capabilities", "", "AUTHINFO USER u", true},
+		{"broken capabilities", "101 caps\r\n", "", false},
+	}
+	for _, test := range tests {
+		replies := map[string]string{}
+		for k, v := range ok {
+			replies[k] = v
+		}
+		if len(test.caps) > 0 {
+			replies["CAPABILITIES"] = test.caps
+		}
+		l, commands := authServer(t, replies)
+		port := l.Addr().(*net.TCPAddr).Port
+		server := &ConfigServer{Address: "127.0.0.1", Port: port, Username: "u", Password: "p", AuthMethod: "auto", AllowCleartextAuth: true}
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		conn, err := simplenntp.DialContext(ctx, simplenntp.Options{Address: server.Address, Port: port})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.AllowCleartextAuth = true
+		// Stale capabilities from before STARTTLS mustn't be used
+		conn.Caps = &simplenntp.Capabilities{AuthInfo: []string{"USER"}}
+		err = authenticate(ctx, conn, server)
+		cancel()
+		conn.Quit()
+		l.Close()
+		if (err == nil) != test.ok {
+			t.Errorf("%s: expected ok=%v, got %v", test.name, test.ok, err)
+		}
+		if <-commands != "CAPABILITIES" {
+			t.Errorf("%s: capabilities weren't asked for", test.name)
+		}
+		if test.ok {
+			if got := <-commands; got != test.first {
+				t.Errorf("%s: got %q, want %q", test.name, got, test.first)
+			}
+		}
+	}
+}
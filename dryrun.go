@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// DryConn stands in for a simplenntp.Conn when nothing should touch the
+// network. Articles are thrown away, or written to dir as .eml files.
+type DryConn struct {
+	dir    string
+	tdchan chan *TimeData
+}
+
+func NewDryConn(dir string, tdchan chan *TimeData) (*DryConn, error) {
+	if len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &DryConn{dir: dir, tdchan: tdchan}, nil
+}
+
+// Post pretends to post an article
+func (d *DryConn) Post(p []byte, chunkSize int64) error {
+	if len(d.dir) > 0 {
+		msgid := articleMessageId(p)
+		if len(msgid) == 0 {
+			return fmt.Errorf("Article has no Message-ID header")
+		}
+		if err := ioutil.WriteFile(spoolPath(d.dir, msgid), p, 0644); err != nil {
+			return err
+		}
+	}
+
+	d.tdchan <- &TimeData{
+		Milliseconds: time.Now().UnixNano() / 1e6,
+		Bytes:        len(p),
+	}
+	return nil
+}
+
+// Quit does nothing, there is no connection to close
+func (d *DryConn) Quit() error {
+	return nil
+}
+
+// articleMessageId returns the Message-ID of an article without the angle brackets
+func articleMessageId(p []byte) string {
+	for _, line := range bytes.Split(p, []byte("\r\n")) {
+		if len(line) == 0 {
+			// End of headers
+			break
+		}
+		if bytes.HasPrefix(bytes.ToLower(line), []byte("message-id:")) {
+			return string(bytes.Trim(bytes.TrimSpace(line[11:]), "<>"))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// How file names are written into subjects and yEnc headers, set with
+// FileNames in the config. utf8 keeps names as they are, ascii transliterates
+// them. Either way the Subject header itself is RFC 2047 encoded where it
+// isn't ASCII, only the yEnc name and the nzb carry raw UTF-8.
+const (
+	FileNamesUTF8  = "utf8"
+	FileNamesASCII = "ascii"
+)
+
+// CheckFileNames checks the FileNames config value
+func CheckFileNames(mode string) error {
+	switch strings.ToLower(mode) {
+	case "", FileNamesUTF8, FileNamesASCII:
+		return nil
+	}
+	return fmt.Errorf("Invalid FileNames '%s', want utf8 or ascii", mode)
+}
+
+// articleFileNames returns the name of a file the way it goes between the
+// quotes in a subject and after name= in the yEnc headers.
+func articleFileNames(name, mode string) (subject, yenc string) {
+	yenc = cleanFileName(name, strings.ToLower(mode))
+	// Downloaders take the name from between the first two quotes in the
+	// subject. yEnc names run to the end of the line so they can keep them.
+	subject = strings.Replace(yenc, `"`, "'", -1)
+	return subject, yenc
+}
+
+// cleanSubject cleans a subject or subject prefix like a file name. These
+// come from flags, the config or directory names and could otherwise smuggle
+// extra headers into an article.
+func cleanSubject(subject, mode string) string {
+	if len(strings.TrimSpace(subject)) == 0 {
+		return ""
+	}
+	return cleanFileName(subject, strings.ToLower(mode))
+}
+
+// cleanFileName replaces what would break a header or yEnc line: control
+// characters and invalid UTF-8 become an underscore and surrounding spaces
+// are dropped. In ascii mode everything else that isn't ASCII is
+// transliterated.
+func cleanFileName(name, mode string) string {
+	var sb strings.Builder
+	for i, r := range name {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(name[i:]); size == 1 {
+				// Not UTF-8 at all
+				r = 0
+			}
+		}
+		switch {
+		case r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0):
+			sb.WriteByte('_')
+		case r < utf8.RuneSelf || mode != FileNamesASCII:
+			sb.WriteRune(r)
+		default:
+			sb.WriteString(transliterate(r))
+		}
+	}
+	clean := strings.Trim(sb.String(), " ")
+	if len(clean) == 0 {
+		return "_"
+	}
+	return clean
+}
+
+// ASCII stand-ins for the accented letters and punctuation that are common
+// in file names
+var transliterations = map[rune]string{}
+
+func init() {
+	table := []string{
+		"ÀÁÂÃÄÅĀĂĄ", "A", "àáâãäåāăą", "a", "Æ", "AE", "æ", "ae",
+		"ÇĆĈĊČ", "C", "çćĉċč", "c", "ĎĐÐ", "D", "ďđð", "d",
+		"ÈÉÊËĒĔĖĘĚ", "E", "èéêëēĕėęě", "e", "ĜĞĠĢ", "G", "ĝğġģ", "g",
+		"ĤĦ", "H", "ĥħ", "h", "ÌÍÎÏĨĪĬĮİ", "I", "ìíîïĩīĭįı", "i",
+		"Ĵ", "J", "ĵ", "j", "Ķ", "K", "ķ", "k", "ĹĻĽĿŁ", "L", "ĺļľŀł", "l",
+		"ÑŃŅŇ", "N", "ñńņň", "n", "ÒÓÔÕÖØŌŎŐ", "O", "òóôõöøōŏő", "o",
+		"Œ", "OE", "œ", "oe", "ŔŖŘ", "R", "ŕŗř", "r", "ŚŜŞŠȘ", "S", "śŝşšș", "s",
+		"ß", "ss", "ŢŤŦȚ", "T", "ţťŧț", "t", "Þ", "TH", "þ", "th",
+		"ÙÚÛÜŨŪŬŮŰŲ", "U", "ùúûüũūŭůűų", "u", "Ŵ", "W", "ŵ", "w",
+		"ÝŶŸ", "Y", "ýÿŷ", "y", "ŹŻŽ", "Z", "źżž", "z",
+		"‘’‚′", "'", "“”„″«»", `"`, "‐‑‒–—―", "-", "…", "...",
+		"€", "EUR", "£", "GBP", "©", "(c)", "®", "(r)", "×", "x",
+		"\u00a0", " ",
+	}
+	for i := 0; i < len(table); i += 2 {
+		for _, r := range table[i] {
+			transliterations[r] = table[i+1]
+		}
+	}
+}
+
+// transliterate returns an ASCII stand-in for r, or an underscore
+func transliterate(r rune) string {
+	if s, ok := transliterations[r]; ok {
+		return s
+	}
+	return "_"
+}
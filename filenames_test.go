@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// How common downloaders find the file name in a subject
+var subjectFileName = regexp.MustCompile(`"([^"]*)"`)
+
+func TestCleanFileName(t *testing.T) {
+	tests := []struct {
+		name, mode, want string
+	}{
+		{"Ürlaub Fotos.jpg", "", "Ürlaub Fotos.jpg"},
+		{"Ürlaub Fotos.jpg", "ascii", "Urlaub Fotos.jpg"},
+		{"Ærøskøbing – straße.zip", "ASCII", "AEroskobing - strasse.zip"},
+		{"“smart” quotes….txt", "ascii", `"smart" quotes....txt`},
+		{"日本語.mkv", "ascii", "___.mkv"},
+		{"日本語.mkv", "utf8", "日本語.mkv"},
+		{"\xff\xfelatin1.bin", "utf8", "__latin1.bin"},
+		{"tab\there\r\n.bin", "", "tab_here__.bin"},
+		{"  spaced  out.bin  ", "", "spaced  out.bin"},
+		{"   ", "", "_"},
+	}
+	for _, test := range tests {
+		if got := cleanFileName(test.name, strings.ToLower(test.mode)); got != test.want {
+			t.Errorf("%q in %q mode: got %q, want %q", test.name, test.mode, got, test.want)
+		}
+	}
+
+	if got := cleanSubject("x\r\nNewsgroups: alt.evil", ""); got != "x__Newsgroups: alt.evil" {
+		t.Errorf("subject came out as %q", got)
+	}
+	if got := cleanSubject("  ", ""); got != "" {
+		t.Errorf("empty subject came out as %q", got)
+	}
+
+	if err := CheckFileNames("latin1"); err == nil {
+		t.Errorf("expected an error for an unknown FileNames mode")
+	}
+}
+
+// TestAwkwardFileNames posts awkward file names and checks that the subject,
+// the yEnc header and the nzb still give the right name back.
+func TestAwkwardFileNames(t *testing.T) {
+	defer func(g ConfigGlobal) { Config.Global = g }(Config.Global)
+	dir, err := ioutil.TempDir("", "gps-filenames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name, mode    string
+		subj, yencEnc string
+	}{
+		{"plain.bin", "", "plain.bin", "plain.bin"},
+		{`with "quotes".bin`, "", "with 'quotes'.bin", `with "quotes".bin`},
+		{"it's.bin", "", "it's.bin", "it's.bin"},
+		{"a=b=c.bin", "", "a=b=c.bin", "a=b=c.bin"},
+		{"=?utf-8?q?sneaky?=.bin", "", "=?utf-8?q?sneaky?=.bin", "=?utf-8?q?sneaky?=.bin"},
+		{"name=x.bin", "", "name=x.bin", "name=x.bin"},
+		{"Ürlaub ä ö.jpg", "", "Ürlaub ä ö.jpg", "Ürlaub ä ö.jpg"},
+		{"Ürlaub ä ö.jpg", "ascii", "Urlaub a o.jpg", "Urlaub a o.jpg"},
+		{"日本語 ファイル.mkv", "", "日本語 ファイル.mkv", "日本語 ファイル.mkv"},
+		{"new\nline.bin", "", "new_line.bin", "new_line.bin"},
+		{" leading and trailing .bin ", "", "leading and trailing .bin", "leading and trailing .bin"},
+		{"back\\slash [1/2] - yEnc (1/1).bin", "", "back\\slash [1/2] - yEnc (1/1).bin", "back\\slash [1/2] - yEnc (1/1).bin"},
+		{"<xml> & stuff.bin", "", "<xml> & stuff.bin", "<xml> & stuff.bin"},
+		{strings.Repeat("long name ", 15) + ".bin", "", strings.Repeat("long name ", 15) + ".bin", strings.Repeat("long name ", 15) + ".bin"},
+	}
+
+	dec := new(mime.WordDecoder)
+	for _, test := range tests {
+		Config.Global = ConfigGlobal{From: "Poster <poster@example.com>", DefaultGroup: "alt.test", FileNames: test.mode}
+		data := &ArticleData{PartNum: 1, PartTotal: 1, PartSize: 3, PartEnd: 3, FileNum: 1, FileTotal: 1, FileSize: 3, FileName: test.name}
+		a := NewArticle([]byte("abc"), data, `My "cool" files`)
+
+		header := string(a.Body[:bytes.Index(a.Body, []byte("\r\n\r\n"))])
+		for _, line := range strings.Split(header, "\r\n") {
+			if !isASCII(line) || strings.ContainsAny(line, "\r\n\x00") {
+				t.Errorf("%q: bad header line %q", test.name, line)
+			}
+		}
+
+		// Raw Subject, unfolded. Indexers take the name from between the
+		// quotes without decoding, so they have to be there as they are.
+		var rawSubject string
+		for _, line := range strings.Split(strings.Replace(header, "\r\n ", " ", -1), "\r\n") {
+			if strings.HasPrefix(line, "Subject: ") {
+				rawSubject = strings.TrimPrefix(line, "Subject: ")
+			}
+		}
+		m := subjectFileName.FindStringSubmatch(rawSubject)
+		if m == nil || !strings.HasSuffix(rawSubject, `" yEnc (1/1)`) || !strings.Contains(rawSubject, ` [1/1] - "`) {
+			t.Errorf("%q: raw subject %q doesn't quote the name", test.name, rawSubject)
+			continue
+		}
+		if isASCII(test.subj) && !strings.Contains(test.subj, "=?") {
+			if m[1] != test.subj {
+				t.Errorf("%q: raw subject %q gives name %q, want %q", test.name, rawSubject, m[1], test.subj)
+			}
+		} else if name, err := dec.DecodeHeader(m[1]); err != nil || name != test.subj {
+			t.Errorf("%q: raw subject %q gives name %q (%q %v), want %q", test.name, rawSubject, m[1], name, err, test.subj)
+		}
+
+		subject, err := dec.DecodeHeader(rawSubject)
+		if err != nil {
+			t.Errorf("%q: can't decode subject: %s", test.name, err)
+		}
+		if subject != a.NzbData.Subject {
+			t.Errorf("%q: subject %q doesn't match the nzb subject %q", test.name, subject, a.NzbData.Subject)
+		}
+
+		// yEnc name runs to the end of the =ybegin line
+		var yencName string
+		for _, line := range strings.Split(string(a.Body), "\r\n") {
+			if strings.HasPrefix(line, "=ybegin ") {
+				yencName = line[strings.Index(line, " name=")+6:]
+			}
+		}
+		if yencName != test.yencEnc {
+			t.Errorf("%q: yEnc name %q, want %q", test.name, yencName, test.yencEnc)
+		}
+
+		// The nzb keeps the subject intact
+		path := filepath.Join(dir, "test.nzb")
+		nzb := &Nzb{File: NzbFiles{a.NzbData}}
+		if err := CreateNzb(path, nzb); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed Nzb
+		if err := xml.Unmarshal(raw, &parsed); err != nil {
+			t.Errorf("%q: nzb doesn't parse: %s", test.name, err)
+		} else if len(parsed.File) != 1 || parsed.File[0].Subject != subject {
+			t.Errorf("%q: nzb subject came back as %+v", test.name, parsed.File)
+		}
+	}
+}
+
+// TestSubjectInjection posts with a directory name that tries to add headers
+// as the subject.
+func TestSubjectInjection(t *testing.T) {
+	defer func(g ConfigGlobal) { Config.Global = g }(Config.Global)
+	Config.Global = ConfigGlobal{From: "Poster <poster@example.com>", DefaultGroup: "alt.test", SubjectPrefix: "pre\nX-Evil: 1"}
+	data := &ArticleData{PartNum: 1, PartTotal: 1, PartSize: 3, PartEnd: 3, FileNum: 1, FileTotal: 1, FileSize: 3, FileName: "a.bin"}
+	a := NewArticle([]byte("abc"), data, "x\r\nNewsgroups: alt.evil")
+
+	header := string(a.Body[:bytes.Index(a.Body, []byte("\r\n\r\n"))])
+	for _, line := range strings.Split(header, "\r\n") {
+		if strings.HasPrefix(line, "X-Evil:") || strings.HasPrefix(line, "Newsgroups: alt.evil") {
+			t.Errorf("injected header %q", line)
+		}
+	}
+	if want := `pre_X-Evil: 1 x__Newsgroups: alt.evil [1/1] - "a.bin" yEnc (1/1)`; a.NzbData.Subject != want {
+		t.Errorf("got subject %q, want %q", a.NzbData.Subject, want)
+	}
+}
@@ -3,7 +3,8 @@ module github.com/tomarus/GoPostStuff
 go 1.13
 
 require (
-	github.com/tomarus/gopoststuff v0.2.0
+	github.com/alexmullins/zip v0.0.0-20180717182244-4affb64b04d0
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
 	gopkg.in/gcfg.v1 v1.2.3
 	gopkg.in/op/go-logging.v1 v1.0.0-20160211212156-b2cb9fa56473
 	gopkg.in/warnings.v0 v0.1.2 // indirect
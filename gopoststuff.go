@@ -33,33 +33,109 @@ var prefixFlag = flag.String("prefix", "", "String to place at the start of ever
 var fromFlag = flag.String("from", "", "The 'From' address to put on posts.")
 var flushConFlag = flag.String("flushcon", "5000", "The time in seconds between temporary disconnects from the Usenet Server to prevent timeouts.")
 var waitTimeFlag = flag.String("waittime", "10", "The waiting time in seconds time before re-connect for flushcon.")
+var archiveFlag = flag.String("archive", "", "Pack files into an encrypted archive before posting - only \"zip\" for now.")
+var archivePassFlag = flag.String("archivepw", "", "Password for -archive, a random one is generated if empty.")
+var splitFlag = flag.Int64("split", 0, "Split files larger than this many bytes into numbered .001, .002, ... volumes.")
+var checksumsFlag = flag.String("checksums", "", "Checksum manifests to post along with the files - any of sfv,md5,sha256.")
+var dryRunFlag = flag.Bool("dry-run", false, "Generate articles and an nzb without connecting to any server.")
+var dryRunDirFlag = flag.String("dry-run-dir", "", "Write -dry-run articles to DIR as .eml files instead of discarding them.")
+var spoolFlag = flag.String("spool", "", "Write articles and a manifest to spool DIR instead of posting them.")
+var progressFlag = flag.String("progress", "auto", "How to report progress - tty, log, json or none. auto uses tty on a terminal, log otherwise.")
+var progressIntervalFlag = flag.Int("progress-interval", 10, "Seconds between progress reports for -progress log and json.")
+var progressFdFlag = flag.Int("progress-fd", 1, "File descriptor to write -progress json events to.")
+var metricsAddrFlag = flag.String("metrics-addr", "", "Serve Prometheus metrics on ADDR, e.g. \":9100\".")
+var statsFlag = flag.String("stats", "", "Write the final statistics report to FILE as JSON.")
+var skipGroupCheckFlag = flag.Bool("skip-group-check", false, "Don't check that the groups exist on every server before posting.")
+var checkServersFlag = flag.Bool("check-servers", false, "Connect to every server, report what it supports and exit.")
+var fromSpoolFlag = flag.String("from-spool", "", "Post the articles in spool DIR written earlier with -spool.")
+var headerFlag headerList
+
 // Logger
 var log = logging.MustGetLogger("gopoststuff")
 
 // Config
 var Config struct {
-	Global ConfigGlobal
-	Server map[string]*ConfigServer
+	Global  ConfigGlobal
+	Server  map[string]*ConfigServer
+	Hooks   ConfigHooks
+	Upload  ConfigUpload
+	Routing ConfigRouting
 }
 
 type ConfigGlobal struct {
-	From          string
-	DefaultGroup  string
-	SubjectPrefix string
-	DefaultNzb    string
-	DefaultServer string
-	ArticleSize   int64
-	ChunkSize     int64
+	From            string
+	DefaultGroup    string
+	SubjectPrefix   string
+	DefaultNzb      string
+	DefaultServer   string
+	ArticleSize     int64
+	ChunkSize       int64
+	PostRetries     int
+	StatsFile       string
+	Archive         string
+	ArchivePassword string
+	SplitSize       int64
+	Checksums       string
+	Header          []string
+	Organization    string
+	FollowupTo      string
+	UserAgent       string
+	Newsposter      string
+	DateFormat      string
+	FileNames       string
+}
+
+type ConfigHooks struct {
+	Command string
+	URL     string
+	Events  string
+	Timeout int
+}
+
+type ConfigUpload struct {
+	Mode      string
+	URL       string
+	APIKey    string
+	Header    []string
+	Retries   int
+	RetryWait int
+	Timeout   int
+	Blackhole string
+}
+
+type ConfigRouting struct {
+	Rule []string
 }
 
 type ConfigServer struct {
-	Address     string
-	Port        int
-	Username    string
-	Password    string
-	Connections int
-	TLS         bool
-	InsecureSSL bool
+	Address      string
+	Port         int
+	Username     string
+	Password     string
+	Connections  int
+	TLS          string
+	InsecureSSL  bool
+	MaxCrosspost int
+
+	AllowCleartextAuth bool
+	CAFile             string
+	ClientCert         string
+	ClientKey          string
+	ServerName         string
+	MinTLSVersion      string
+	PinSHA256          []string
+	Proxy              string
+	BindAddress        []string
+	ConnectTimeout     int
+	ReadTimeout        int
+	WriteTimeout       int
+	IdleTimeout        int
+	Compress           bool
+	AuthMethod         string
+	UsernameEnv        string
+	PasswordEnv        string
+	PasswordCommand    string
+	NetrcFile          string
 }
 
 func main() {
@@ -71,6 +147,7 @@ func main() {
 	}
 
 	// Parse command line flags
+	flag.Var(&headerFlag, "header", "Extra \"Name: value\" header to add to every article, may be repeated.")
 	flag.Parse()
 
 	// Check for version argument
@@ -91,26 +168,34 @@ func main() {
 
 	log.Info("gopoststuff starting...")
 
-	// Make sure -d or -s was specified
-	if len(*subjectFlag) == 0 && !*dirSubjectFlag {
-		log.Fatal("Need to specify -d or -s option, try gopoststuff --help")
+	// Spooling a spool again would leave the new one without a manifest
+	if len(*fromSpoolFlag) > 0 && len(*spoolFlag) > 0 {
+		log.Fatal("-from-spool can't be combined with -spool")
 	}
 
-	// Check arguments
-	if len(flag.Args()) == 0 {
-		log.Fatal("No filenames provided")
-	}
+	// Posting a spool or checking servers doesn't need any files
+	if len(*fromSpoolFlag) == 0 && !*checkServersFlag {
+		// Make sure -d or -s was specified
+		if len(*subjectFlag) == 0 && !*dirSubjectFlag {
+			log.Fatal("Need to specify -d or -s option, try gopoststuff --help")
+		}
 
-	// Check that all supplied arguments exist
-	for _, arg := range flag.Args() {
-		st, err := os.Stat(arg)
-		if err != nil {
-			log.Fatalf("stat %s: %s", arg, err)
+		// Check arguments
+		if len(flag.Args()) == 0 {
+			log.Fatal("No filenames provided")
 		}
 
-		// If -d was specified, make sure that it's a directory
-		if *dirSubjectFlag && !st.IsDir() {
-			log.Fatalf("-d option used but not a directory: %s", arg)
+		// Check that all supplied arguments exist
+		for _, arg := range flag.Args() {
+			st, err := os.Stat(arg)
+			if err != nil {
+				log.Fatalf("stat %s: %s", arg, err)
+			}
+
+			// If -d was specified, make sure that it's a directory
+			if *dirSubjectFlag && !st.IsDir() {
+				log.Fatalf("-d option used but not a directory: %s", arg)
+			}
 		}
 	}
 
@@ -139,6 +224,17 @@ func main() {
 		Config.Global.ChunkSize = 10240
 	}
 
+	if err := CheckServerConfig(Config.Server); err != nil {
+		log.Fatal(err)
+	}
+
+	if articleHeaders, err = ArticleHeaders(Config.Global, headerFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := CheckFileNames(Config.Global.FileNames); err != nil {
+		log.Fatal(err)
+	}
+
 	// Maybe set GOMAXPROCS
 	if *allCpuFlag {
 		runtime.GOMAXPROCS(runtime.NumCPU())
@@ -156,8 +252,33 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	hooks = NewHooks(Config.Hooks)
+
+	if len(*metricsAddrFlag) > 0 {
+		StartMetricsServer(*metricsAddrFlag)
+	}
+
+	if *checkServersFlag {
+		servers := Config.Server
+		if len(*serverFlag) > 0 {
+			server, ok := Config.Server[*serverFlag]
+			if !ok {
+				log.Fatalf("Unknown server '%s'", *serverFlag)
+			}
+			servers = map[string]*ConfigServer{*serverFlag: server}
+		}
+		if !CheckServers(servers) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Start the magical spawner
-	Spawner(flag.Args())
+	if len(*fromSpoolFlag) > 0 {
+		SpoolSpawner(*fromSpoolFlag)
+	} else {
+		Spawner(flag.Args())
+	}
 
 	if *cpuProfileFlag != "" {
 		log.Info("CPU profiling data saved to %s", *cpuProfileFlag)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+)
+
+// groupLister is the part of simplenntp.Conn needed to check groups
+type groupLister interface {
+	ListActive(wildmat string) ([]simplenntp.Group, error)
+}
+
+// CheckGroups makes sure every group exists and can be posted to on every
+// server before anything is posted. A server that can't list its groups is
+// an error too.
+func CheckGroups(servers map[string]*ConfigServer, groups []string) error {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var bad []string
+	for _, name := range names {
+		log.Info("[%s] Checking %d group(s)", name, len(groups))
+		conn, err := connect(name, 0, servers[name], nil)
+		if err != nil {
+			return fmt.Errorf("[%s] %s", name, err)
+		}
+		problems, err := badGroups(conn, groups)
+		conn.Quit()
+		if err != nil {
+			return fmt.Errorf("[%s] Unable to check %s: %s (use -skip-group-check to post anyway)", name, strings.Join(groups, ", "), err)
+		}
+		for _, p := range problems {
+			bad = append(bad, fmt.Sprintf("%s on %s", p, name))
+		}
+	}
+
+	if len(bad) > 0 {
+		return fmt.Errorf("Can't post to: %s", strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// badGroups returns a description of every group that doesn't exist or
+// can't be posted to.
+func badGroups(conn groupLister, groups []string) ([]string, error) {
+	var bad []string
+	for _, group := range groups {
+		list, err := conn.ListActive(group)
+		if err != nil {
+			return nil, err
+		}
+
+		var found *simplenntp.Group
+		for i := range list {
+			if list[i].Name == group {
+				found = &list[i]
+				break
+			}
+		}
+		switch {
+		case found == nil:
+			bad = append(bad, fmt.Sprintf("%s (does not exist)", group))
+		case !found.Postable():
+			bad = append(bad, fmt.Sprintf("%s (status '%s', not postable)", group, found.Status))
+		case found.Status == "m":
+			log.Warning("Group %s is moderated, posts will go to the moderator", group)
+		}
+	}
+	return bad, nil
+}
+
+// checkGroups runs the pre-flight group check unless posting offline or
+// told not to.
+func checkGroups(serverList map[string]*ConfigServer, groupLists []string) {
+	if offline() || *skipGroupCheckFlag {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var groups []string
+	for _, list := range groupLists {
+		for _, g := range strings.Split(list, ",") {
+			if len(g) > 0 && !seen[g] {
+				seen[g] = true
+				groups = append(groups, g)
+			}
+		}
+	}
+	sort.Strings(groups)
+
+	if err := CheckGroups(serverList, groups); err != nil {
+		fatalf("Group error: %s", err)
+	}
+}
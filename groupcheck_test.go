@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+)
+
+type fakeLister map[string]string
+
+func (f fakeLister) ListActive(wildmat string) ([]simplenntp.Group, error) {
+	if wildmat == "broken" {
+		return nil, fmt.Errorf("broken")
+	}
+	if status, ok := f[wildmat]; ok {
+		return []simplenntp.Group{{Name: wildmat, High: 2, Low: 1, Status: status}}, nil
+	}
+	return nil, nil
+}
+
+func TestBadGroups(t *testing.T) {
+	lister := fakeLister{
+		"alt.binaries.test":   "y",
+		"alt.binaries.mod":    "m",
+		"alt.binaries.closed": "n",
+	}
+
+	bad, err := badGroups(lister, []string{"alt.binaries.test", "alt.binaries.mod", "alt.binaries.closed", "alt.binaries.tset"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"alt.binaries.closed (status 'n', not postable)",
+		"alt.binaries.tset (does not exist)",
+	}
+	if !reflect.DeepEqual(bad, expected) {
+		t.Errorf("expected %q, got %q", expected, bad)
+	}
+
+	if _, err := badGroups(lister, []string{"broken"}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestCheckGroups(t *testing.T) {
+	list := map[string]string{
+		"LIST ACTIVE alt.test": "215 list\r\nalt.test 2 1 y\r\n.\r\n",
+		"QUIT":                 "205 bye\r\n",
+	}
+	good, _ := authServer(t, list)
+	defer good.Close()
+	// Doesn't know LIST ACTIVE
+	broken, _ := authServer(t, map[string]string{"QUIT": "205 bye\r\n"})
+	defer broken.Close()
+
+	servers := map[string]*ConfigServer{
+		"good": {Address: "127.0.0.1", Port: good.Addr().(*net.TCPAddr).Port},
+	}
+	if err := CheckGroups(servers, []string{"alt.test"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	servers["broken"] = &ConfigServer{Address: "127.0.0.1", Port: broken.Addr().(*net.TCPAddr).Port}
+	err := CheckGroups(servers, []string{"alt.test"})
+	if err == nil || !strings.Contains(err.Error(), "[broken] Unable to check alt.test") {
+		t.Errorf("expected an error for the broken server, got %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Headers added to every article on top of the generated ones, set up by
+// main from the config and -header flags.
+var articleHeaders []Header
+
+// Headers that are always generated and can't be set by hand
+var reservedHeaders = []string{"From", "Newsgroups", "Message-ID", "Subject", "Date", "Path"}
+
+// Longest line allowed by RFC 5322 and the length lines are folded at
+const (
+	maxHeaderLine  = 998
+	foldHeaderLine = 78
+)
+
+// Header is a single article header
+type Header struct {
+	Name  string
+	Value string
+}
+
+// headerList collects repeated -header flags
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(v string) error {
+	*h = append(*h, v)
+	return nil
+}
+
+// ParseHeader parses and checks a "Name: value" header line
+func ParseHeader(line string) (Header, error) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return Header{}, fmt.Errorf("Header '%s' is not in 'Name: value' form", line)
+	}
+	h := Header{Name: strings.TrimSpace(parts[0]), Value: strings.TrimSpace(parts[1])}
+	if err := h.check(); err != nil {
+		return Header{}, err
+	}
+	return h, nil
+}
+
+// check makes sure the header is valid RFC 5322 syntax and can be folded
+// into lines no longer than 998 characters.
+func (h Header) check() error {
+	if len(h.Name) == 0 {
+		return fmt.Errorf("Header '%s' has no name", h.Name)
+	}
+	for _, c := range h.Name {
+		// Printable US-ASCII except the colon
+		if c < 33 || c > 126 || c == ':' {
+			return fmt.Errorf("Header name '%s' contains invalid character %q", h.Name, c)
+		}
+	}
+	if len(h.Value) == 0 {
+		return fmt.Errorf("Header '%s' has an empty value", h.Name)
+	}
+	for _, c := range h.Value {
+		if c > 126 {
+			return fmt.Errorf("Header '%s' contains non-ASCII character %q", h.Name, c)
+		}
+		if c < 32 && c != '\t' {
+			return fmt.Errorf("Header '%s' contains control character %q", h.Name, c)
+		}
+	}
+	for i, word := range strings.Fields(h.Value) {
+		length := len(word)
+		if i == 0 {
+			length += len(h.Name) + 2
+		}
+		if length > maxHeaderLine {
+			return fmt.Errorf("Header '%s' has a word too long to fold into %d character lines", h.Name, maxHeaderLine)
+		}
+	}
+	return nil
+}
+
+// String returns the header folded into lines of at most 78 characters
+// where possible, ending in CRLF. Lines are only broken before a space that
+// is already there, so unfolding gives back the exact value.
+func (h Header) String() string {
+	var sb strings.Builder
+	sb.WriteString(h.Name + ":")
+	lineLen := sb.Len()
+	for i, word := range strings.Split(h.Value, " ") {
+		if i > 0 && len(word) > 0 && lineLen+1+len(word) > foldHeaderLine {
+			sb.WriteString("\r\n")
+			lineLen = 0
+		}
+		sb.WriteString(" " + word)
+		lineLen += 1 + len(word)
+	}
+	sb.WriteString("\r\n")
+	return sb.String()
+}
+
+// BuildHeaders returns the headers of an article in the order they are
+// written: the generated ones followed by articleHeaders. The Subject and
+// the name in From are RFC 2047 encoded if they aren't plain ASCII. Control
+// characters in the generated values become spaces, so they can't end a
+// header early.
+func BuildHeaders(from, groups, msgid, subject string, t time.Time) []Header {
+	from, groups, msgid, subject = cleanValue(from), cleanValue(groups), cleanValue(msgid), cleanValue(subject)
+	date, err := dateHeader(Config.Global.DateFormat, t)
+	if err != nil {
+		// Already checked by ArticleHeaders
+		date, _ = dateHeader("", t)
+	}
+	headers := []Header{
+		{"From", encodeFrom(from)},
+		{"Newsgroups", groups},
+		{"Subject", encodeWords(subject)},
+		{"Message-ID", "<" + msgid + ">"},
+		*date,
+		// RFC 5537 section 3.4, the server adds itself in front
+		{"Path", "not-for-mail"},
+	}
+	return append(headers, articleHeaders...)
+}
+
+// cleanValue replaces CR, LF, NUL and the other control characters but tab
+// in a header value with spaces
+func cleanValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r < 32 && r != '\t') || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// encodeFrom RFC 2047 encodes the display name of a From address that isn't
+// plain ASCII.
+func encodeFrom(from string) string {
+	if isASCII(from) {
+		return from
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return encodeWords(from)
+	}
+	// String encodes the name, and quotes it if needed
+	return addr.String()
+}
+
+// encodeWords RFC 2047 encodes every run of words in s that aren't plain
+// ASCII, or would be mistaken for an encoded word, leaving the rest readable.
+// Quotes are never encoded, downloaders and indexers read the file name from
+// between them in the raw subject.
+func encodeWords(s string) string {
+	if isASCII(s) && !strings.Contains(s, "=?") {
+		return s
+	}
+	parts := strings.Split(s, `"`)
+	for i := range parts {
+		parts[i] = encodeRuns(parts[i])
+	}
+	return strings.Join(parts, `"`)
+}
+
+// encodeRuns does the work for encodeWords on a part without quotes
+func encodeRuns(s string) string {
+	if isASCII(s) && !strings.Contains(s, "=?") {
+		return s
+	}
+	plain := func(word string) bool {
+		return isASCII(word) && !strings.Contains(word, "=?")
+	}
+	words := strings.Split(s, " ")
+	var out []string
+	for i := 0; i < len(words); {
+		if plain(words[i]) {
+			out = append(out, words[i])
+			i++
+			continue
+		}
+		// Spaces between encoded words are dropped when decoding, so
+		// neighbours go into the same one
+		j := i
+		for j < len(words) && !plain(words[j]) {
+			j++
+		}
+		run := strings.Join(words[i:j], " ")
+		if isASCII(run) {
+			out = append(out, encodeASCII(run))
+		} else {
+			out = append(out, mime.QEncoding.Encode("utf-8", run))
+		}
+		i = j
+	}
+	return strings.Join(out, " ")
+}
+
+// encodeASCII B encodes s by hand, mime leaves plain ASCII alone
+func encodeASCII(s string) string {
+	var words []string
+	for len(s) > 0 {
+		// Keeps each encoded word within 75 characters
+		n := len(s)
+		if n > 45 {
+			n = 45
+		}
+		words = append(words, "=?utf-8?b?"+base64.StdEncoding.EncodeToString([]byte(s[:n]))+"?=")
+		s = s[n:]
+	}
+	return strings.Join(words, " ")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// ArticleHeaders works out the extra headers for every article from the
+// config and the -header flags. Headers given later replace earlier ones
+// with the same name, so flags override the config. A Newsposter or
+// UserAgent of "none" leaves that header out.
+func ArticleHeaders(cfg ConfigGlobal, flags []string) ([]Header, error) {
+	var headers []Header
+	set := func(h Header) error {
+		if err := h.check(); err != nil {
+			return err
+		}
+		for _, name := range reservedHeaders {
+			if strings.EqualFold(h.Name, name) {
+				return fmt.Errorf("Header '%s' is generated and can't be set", h.Name)
+			}
+		}
+		for i := range headers {
+			if strings.EqualFold(headers[i].Name, h.Name) {
+				headers[i] = h
+				return nil
+			}
+		}
+		headers = append(headers, h)
+		return nil
+	}
+
+	newsposter := cfg.Newsposter
+	if len(newsposter) == 0 {
+		newsposter = "KereMagicPoster"
+	}
+	optional := []Header{
+		{"X-Newsposter", newsposter},
+		{"User-Agent", cfg.UserAgent},
+		{"Organization", cfg.Organization},
+		{"Followup-To", cfg.FollowupTo},
+	}
+	for _, h := range optional {
+		if len(h.Value) > 0 && !strings.EqualFold(h.Value, "none") {
+			if err := set(h); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	lines := append(append([]string{}, cfg.Header...), flags...)
+	for _, line := range lines {
+		h, err := ParseHeader(line)
+		if err != nil {
+			return nil, err
+		}
+		if err := set(h); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := dateHeader(cfg.DateFormat, time.Now()); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// dateHeader formats t for the Date header (RFC 5322 section 3.3). format is
+// "local", the default, or "utc".
+func dateHeader(format string, t time.Time) (*Header, error) {
+	switch strings.ToLower(format) {
+	case "", "local":
+	case "utc":
+		t = t.UTC()
+	default:
+		return nil, fmt.Errorf("Invalid DateFormat '%s', want local or utc", format)
+	}
+	return &Header{"Date", t.Format("Mon, 02 Jan 2006 15:04:05 -0700")}, nil
+}
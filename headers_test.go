@@ -0,0 +1,251 @@
+package main
+
+import (
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+	good := map[string]Header{
+		"X-No-Archive: yes":         {"X-No-Archive", "yes"},
+		"  Organization :Pants Inc": {"Organization", "Pants Inc"},
+		"X-Url: http://example.com": {"X-Url", "http://example.com"},
+		"X-Tab: a\tb":               {"X-Tab", "a\tb"},
+	}
+	for line, want := range good {
+		h, err := ParseHeader(line)
+		if err != nil {
+			t.Errorf("%q: %s", line, err)
+		} else if h != want {
+			t.Errorf("%q: got %+v, want %+v", line, h, want)
+		}
+	}
+
+	bad := []string{
+		"no colon",
+		": no name",
+		"X-Empty:",
+		"X Space: name",
+		"X-Ctrl: a\x01b",
+		"X-Newline: a\r\nb",
+		"X-Utf8: héllo",
+		"X-Long: " + strings.Repeat("x", 1000),
+	}
+	for _, line := range bad {
+		if _, err := ParseHeader(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestHeaderFolding(t *testing.T) {
+	h := Header{"X-Comment", strings.Repeat("word ", 40)}
+	s := h.String()
+	if !strings.HasSuffix(s, "\r\n") {
+		t.Errorf("header doesn't end in CRLF: %q", s)
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\r\n"), "\r\n")
+	if len(lines) < 3 {
+		t.Errorf("expected the header to be folded, got %q", s)
+	}
+	for i, line := range lines {
+		if len(line) > foldHeaderLine {
+			t.Errorf("line %d is %d characters long", i, len(line))
+		}
+		if i > 0 && line[0] != ' ' {
+			t.Errorf("continuation line %d doesn't start with whitespace: %q", i, line)
+		}
+	}
+	unfolded := strings.Replace(strings.TrimSuffix(s, "\r\n"), "\r\n", "", -1)
+	if unfolded != "X-Comment: "+h.Value {
+		t.Errorf("unfolding doesn't give the value back: %q", unfolded)
+	}
+
+	// Whitespace is kept as it is
+	spaced := Header{"Subject", "two  spaces " + strings.Repeat("x", 70) + "  end"}
+	unfolded = strings.Replace(strings.TrimSuffix(spaced.String(), "\r\n"), "\r\n", "", -1)
+	if unfolded != "Subject: "+spaced.Value {
+		t.Errorf("unfolding doesn't give the value back: %q", unfolded)
+	}
+
+	// A long first word stays on the first line
+	long := Header{"X-Id", strings.Repeat("x", 100)}
+	if s := long.String(); s != "X-Id: "+long.Value+"\r\n" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestArticleHeaders(t *testing.T) {
+	cfg := ConfigGlobal{
+		Organization: "Pants Inc",
+		UserAgent:    "GoPostStuff/test",
+		Header:       []string{"X-No-Archive: no", "X-Comment: config"},
+	}
+	headers, err := ArticleHeaders(cfg, []string{"x-no-archive: yes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Header{
+		{"X-Newsposter", "KereMagicPoster"},
+		{"User-Agent", "GoPostStuff/test"},
+		{"Organization", "Pants Inc"},
+		{"x-no-archive", "yes"},
+		{"X-Comment", "config"},
+	}
+	if len(headers) != len(want) {
+		t.Fatalf("got %+v", headers)
+	}
+	for i := range want {
+		if headers[i] != want[i] {
+			t.Errorf("header %d: got %+v, want %+v", i, headers[i], want[i])
+		}
+	}
+
+	cfg = ConfigGlobal{Newsposter: "none"}
+	if headers, err := ArticleHeaders(cfg, nil); err != nil || len(headers) != 0 {
+		t.Errorf("expected no headers, got %+v %v", headers, err)
+	}
+
+	for _, flag := range []string{"Subject: mine", "message-id: <x@y>", "Date: today", "broken"} {
+		if _, err := ArticleHeaders(ConfigGlobal{}, []string{flag}); err == nil {
+			t.Errorf("%q: expected an error", flag)
+		}
+	}
+	if _, err := ArticleHeaders(ConfigGlobal{DateFormat: "tomorrow"}, nil); err == nil {
+		t.Errorf("expected an error for a bad DateFormat")
+	}
+}
+
+func TestDateHeader(t *testing.T) {
+	when := time.Date(2026, 10, 19, 12, 34, 56, 0, time.FixedZone("CEST", 2*3600))
+	for _, format := range []string{"", "local"} {
+		h, err := dateHeader(format, when)
+		if err != nil || h.Value != "Mon, 19 Oct 2026 12:34:56 +0200" {
+			t.Errorf("%q: got %+v %v", format, h, err)
+		}
+	}
+	h, err := dateHeader("UTC", when)
+	if err != nil || h.Value != "Mon, 19 Oct 2026 10:34:56 +0000" {
+		t.Errorf("got %+v %v", h, err)
+	}
+}
+
+// headerValues unfolds the headers written by BuildHeaders and returns them
+// by name, checking that every line is fine for RFC 5322.
+func headerValues(t *testing.T, headers []Header) map[string]string {
+	values := make(map[string]string)
+	for _, h := range headers {
+		s := h.String()
+		for _, line := range strings.Split(strings.TrimSuffix(s, "\r\n"), "\r\n") {
+			if len(line) > foldHeaderLine && strings.Contains(strings.TrimSpace(line), " ") {
+				t.Errorf("%s: line not folded: %q", h.Name, line)
+			}
+			if !isASCII(line) {
+				t.Errorf("%s: line isn't ASCII: %q", h.Name, line)
+			}
+		}
+		if _, ok := values[h.Name]; ok {
+			t.Errorf("%s: header written twice", h.Name)
+		}
+		unfolded := strings.Replace(strings.TrimSuffix(s, "\r\n"), "\r\n", "", -1)
+		values[h.Name] = strings.TrimPrefix(unfolded, h.Name+": ")
+	}
+	return values
+}
+
+func TestBuildHeaders(t *testing.T) {
+	defer func(h []Header) { articleHeaders = h }(articleHeaders)
+	articleHeaders = []Header{{"X-No-Archive", "yes"}}
+	when := time.Date(2026, 10, 19, 12, 34, 56, 0, time.UTC)
+	subject := "Holiday [1/2] - \"" + strings.Repeat("long_name_", 12) + ".bin\" yEnc (1/10)"
+	headers := BuildHeaders("Poster <poster@example.com>", "alt.test,alt.test.yenc", "123$gps@host", subject, when)
+
+	names := make([]string, len(headers))
+	for i, h := range headers {
+		names[i] = h.Name
+	}
+	if strings.Join(names, " ") != "From Newsgroups Subject Message-ID Date Path X-No-Archive" {
+		t.Errorf("got headers %v", names)
+	}
+
+	values := headerValues(t, headers)
+	if values["From"] != "Poster <poster@example.com>" {
+		t.Errorf("From: got %q", values["From"])
+	}
+	if values["Newsgroups"] != "alt.test,alt.test.yenc" {
+		t.Errorf("Newsgroups: got %q", values["Newsgroups"])
+	}
+	if values["Subject"] != subject {
+		t.Errorf("Subject: got %q", values["Subject"])
+	}
+	if values["Message-ID"] != "<123$gps@host>" {
+		t.Errorf("Message-ID: got %q", values["Message-ID"])
+	}
+	if date, err := mail.ParseDate(values["Date"]); err != nil || !date.Equal(when) {
+		t.Errorf("Date: got %q %v", values["Date"], err)
+	}
+	if values["Path"] != "not-for-mail" {
+		t.Errorf("Path: got %q", values["Path"])
+	}
+	if values["X-No-Archive"] != "yes" {
+		t.Errorf("X-No-Archive: got %q", values["X-No-Archive"])
+	}
+}
+
+func TestBuildHeadersNonASCII(t *testing.T) {
+	subject := "Ürlaub Fotos 2026 [1/1] - \"" + strings.Repeat("日本語", 10) + ".jpg\" yEnc (1/1)"
+	from := "Jörg Müller <joerg@example.com>"
+	values := headerValues(t, BuildHeaders(from, "alt.test", "1$gps@host", subject, time.Now()))
+
+	dec := new(mime.WordDecoder)
+	got, err := dec.DecodeHeader(values["Subject"])
+	if err != nil || got != subject {
+		t.Errorf("Subject: %q decodes to %q %v", values["Subject"], got, err)
+	}
+	if !strings.Contains(values["Subject"], " Fotos 2026 [1/1] - ") || !strings.HasSuffix(values["Subject"], " yEnc (1/1)") {
+		t.Errorf("Subject: ASCII words should stay readable: %q", values["Subject"])
+	}
+
+	addr, err := mail.ParseAddress(values["From"])
+	if err != nil || addr.Name != "Jörg Müller" || addr.Address != "joerg@example.com" {
+		t.Errorf("From: %q parses to %+v %v", values["From"], addr, err)
+	}
+}
+
+// TestBuildHeadersRawSubject checks the Subject as it goes over the wire,
+// indexers read the file name from between the quotes without decoding.
+func TestBuildHeadersRawSubject(t *testing.T) {
+	tests := map[string]string{
+		`My Subject [3/5] - "wéird 'na=me'.txt" yEnc (1/1)`: `My Subject [3/5] - "=?utf-8?q?w=C3=A9ird?= 'na=me'.txt" yEnc (1/1)`,
+		`Pics [1/1] - "日本語 ファイル.mkv" yEnc (1/1)`:            `Pics [1/1] - "=?utf-8?q?=E6=97=A5=E6=9C=AC=E8=AA=9E_=E3=83=95=E3=82=A1=E3=82=A4?= =?utf-8?q?=E3=83=AB.mkv?=" yEnc (1/1)`,
+		`Ürlaub [1/1] - "=?utf-8?q?x?=.bin" yEnc (1/1)`:     `=?utf-8?q?=C3=9Crlaub?= [1/1] - "=?utf-8?b?PT91dGYtOD9xP3g/PS5iaW4=?=" yEnc (1/1)`,
+		`Plain [1/1] - "plain.bin" yEnc (1/1)`:              `Plain [1/1] - "plain.bin" yEnc (1/1)`,
+	}
+	dec := new(mime.WordDecoder)
+	for subject, want := range tests {
+		values := headerValues(t, BuildHeaders("Poster <poster@example.com>", "alt.test", "1$gps@host", subject, time.Now()))
+		if values["Subject"] != want {
+			t.Errorf("%q: got %q, want %q", subject, values["Subject"], want)
+		}
+		if got, err := dec.DecodeHeader(values["Subject"]); err != nil || got != subject {
+			t.Errorf("%q: decodes to %q %v", subject, got, err)
+		}
+	}
+}
+
+func TestBuildHeadersControlCharacters(t *testing.T) {
+	headers := BuildHeaders("Poster\r\nX-Evil: 1 <poster@example.com>", "alt.test\nControl: cancel", "1$gps@host\x00", "a\rb\x7f", time.Now())
+	for _, h := range headers {
+		if err := h.check(); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+	values := headerValues(t, headers)
+	if values["From"] != "Poster  X-Evil: 1 <poster@example.com>" || values["Newsgroups"] != "alt.test Control: cancel" ||
+		values["Message-ID"] != "<1$gps@host >" || values["Subject"] != "a b " {
+		t.Errorf("got %q", values)
+	}
+}
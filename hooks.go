@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hook event names
+const (
+	HookStart    = "start"
+	HookFileDone = "file_done"
+	HookSuccess  = "success"
+	HookFailure  = "failure"
+)
+
+var hookEvents = []string{HookStart, HookFileDone, HookSuccess, HookFailure}
+
+// Hooks used by the running job, replaced by main once the config is loaded
+var hooks = NewHooks(ConfigHooks{})
+
+// HookEvent is passed to hook commands as GPS_* environment variables and
+// POSTed to webhooks as JSON.
+type HookEvent struct {
+	Event   string `json:"event"`
+	Time    int64  `json:"time"`
+	Status  string `json:"status"`
+	Subject string `json:"subject,omitempty"`
+	File    string `json:"file,omitempty"`
+	Files   int    `json:"files,omitempty"`
+	Bytes   int64  `json:"bytes"`
+	Nzb     string `json:"nzb,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Hooks runs a command and/or calls a webhook when something happens
+type Hooks struct {
+	command string
+	url     string
+	events  map[string]bool
+	timeout time.Duration
+	client  *http.Client
+	subject string
+	files   int
+	bytes   int64
+	failed  sync.Once
+	sync.Mutex
+}
+
+func NewHooks(cfg ConfigHooks) *Hooks {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	events := make(map[string]bool)
+	for _, e := range strings.Split(cfg.Events, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if len(e) > 0 {
+			events[e] = true
+		}
+	}
+	if len(events) == 0 {
+		for _, e := range hookEvents {
+			events[e] = true
+		}
+	}
+
+	return &Hooks{
+		command: cfg.Command,
+		url:     cfg.URL,
+		events:  events,
+		timeout: timeout,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// SetJob remembers what the current job is about so every event includes it
+func (h *Hooks) SetJob(subject string, files int, bytes int64) {
+	h.Lock()
+	h.subject = subject
+	h.files = files
+	h.bytes = bytes
+	h.Unlock()
+}
+
+// Fire runs the hooks for an event and waits for them to finish
+func (h *Hooks) Fire(e *HookEvent) {
+	if (len(h.command) == 0 && len(h.url) == 0) || !h.events[e.Event] {
+		return
+	}
+
+	h.Lock()
+	if len(e.Subject) == 0 {
+		e.Subject = h.subject
+	}
+	if e.Files == 0 {
+		e.Files = h.files
+	}
+	if e.Bytes == 0 {
+		e.Bytes = h.bytes
+	}
+	h.Unlock()
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+
+	if len(h.command) > 0 {
+		if err := h.runCommand(e); err != nil {
+			log.Warning("Hook command for %s failed: %s", e.Event, err)
+		}
+	}
+	if len(h.url) > 0 {
+		if err := h.postWebhook(e); err != nil {
+			log.Warning("Webhook for %s failed: %s", e.Event, err)
+		}
+	}
+}
+
+func (h *Hooks) runCommand(e *HookEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.command)
+	cmd.Env = append(os.Environ(),
+		"GPS_EVENT="+e.Event,
+		"GPS_STATUS="+e.Status,
+		"GPS_SUBJECT="+e.Subject,
+		"GPS_FILE="+e.File,
+		fmt.Sprintf("GPS_FILES=%d", e.Files),
+		fmt.Sprintf("GPS_BYTES=%d", e.Bytes),
+		"GPS_NZB="+e.Nzb,
+		"GPS_ERROR="+e.Error,
+	)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		log.Debug("Hook command output: %s", strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+func (h *Hooks) postWebhook(e *HookEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", h.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoPostStuff/"+GPS_VERSION)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", h.url, resp.Status)
+	}
+	return nil
+}
+
+// hookRenderer fires file_done hooks from the progress events. The hooks run
+// in order on a worker of their own, so slow ones don't hold up the status
+// loop.
+type hookRenderer struct {
+	ProgressRenderer
+	queue  []*HookEvent
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+	sync.Mutex
+}
+
+func newHookRenderer(renderer ProgressRenderer) *hookRenderer {
+	r := &hookRenderer{
+		ProgressRenderer: renderer,
+		wake:             make(chan struct{}, 1),
+		done:             make(chan struct{}),
+	}
+	go r.worker()
+	return r
+}
+
+func (r *hookRenderer) Render(e *ProgressEvent) {
+	r.ProgressRenderer.Render(e)
+	if e.Event == "file_done" {
+		r.Lock()
+		r.queue = append(r.queue, &HookEvent{Event: HookFileDone, Status: "ok", File: e.File.Name, Bytes: e.File.Size})
+		r.Unlock()
+		r.signal()
+	}
+}
+
+// Wait waits for the queued hooks to finish. Nothing may be rendered after.
+func (r *hookRenderer) Wait() {
+	r.Lock()
+	r.closed = true
+	r.Unlock()
+	r.signal()
+	<-r.done
+}
+
+func (r *hookRenderer) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *hookRenderer) worker() {
+	defer close(r.done)
+	for {
+		r.Lock()
+		if len(r.queue) == 0 {
+			closed := r.closed
+			r.Unlock()
+			if closed {
+				return
+			}
+			<-r.wake
+			continue
+		}
+		e := r.queue[0]
+		r.queue = r.queue[1:]
+		r.Unlock()
+		hooks.Fire(e)
+	}
+}
+
+// Cleanups for fatalf to run, exiting skips deferred calls
+var fatalCleanups struct {
+	funcs []func()
+	sync.Mutex
+}
+
+// onFatal makes fatalf run f before exiting
+func onFatal(f func()) {
+	fatalCleanups.Lock()
+	fatalCleanups.funcs = append(fatalCleanups.funcs, f)
+	fatalCleanups.Unlock()
+}
+
+// fatalf fires the failure hooks once, runs the onFatal cleanups and then
+// exits like log.Fatalf
+func fatalf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	hooks.failed.Do(func() {
+		hooks.Fire(&HookEvent{Event: HookFailure, Status: "failed", Error: msg})
+	})
+	fatalCleanups.Lock()
+	for _, f := range fatalCleanups.funcs {
+		f()
+	}
+	fatalCleanups.Unlock()
+	log.Fatal(msg)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooksWebhook(t *testing.T) {
+	events := make(chan HookEvent, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected application/json, got %s", ct)
+		}
+		var e HookEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("couldn't decode event: %s", err)
+		}
+		events <- e
+	}))
+	defer ts.Close()
+
+	h := NewHooks(ConfigHooks{URL: ts.URL, Events: "start,success"})
+	h.SetJob("Cool Files", 3, 1234)
+	h.Fire(&HookEvent{Event: HookStart, Status: "running"})
+	h.Fire(&HookEvent{Event: HookFileDone, Status: "ok", File: "cool.rar"})
+	h.Fire(&HookEvent{Event: HookSuccess, Status: "ok", Nzb: "cool.nzb"})
+	close(events)
+
+	var got []HookEvent
+	for e := range events {
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d: %+v", len(got), got)
+	}
+	if got[0].Event != HookStart || got[0].Subject != "Cool Files" || got[0].Files != 3 || got[0].Bytes != 1234 {
+		t.Errorf("unexpected start event: %+v", got[0])
+	}
+	if got[1].Event != HookSuccess || got[1].Nzb != "cool.nzb" || got[1].Status != "ok" {
+		t.Errorf("unexpected success event: %+v", got[1])
+	}
+}
+
+func TestHooksWebhookError(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	h := NewHooks(ConfigHooks{URL: ts.URL})
+	if err := h.postWebhook(&HookEvent{Event: HookFailure}); err == nil {
+		t.Fatalf("expected an error for a 500 response")
+	}
+	if hits != 1 {
+		t.Fatalf("expected 1 request, got %d", hits)
+	}
+}
+
+func TestHooksCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gps-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	h := NewHooks(ConfigHooks{Command: "echo \"$GPS_EVENT $GPS_STATUS $GPS_NZB $GPS_BYTES\" > " + out})
+	h.SetJob("Cool Files", 1, 42)
+	h.Fire(&HookEvent{Event: HookSuccess, Status: "ok", Nzb: "cool.nzb"})
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hook command didn't run: %s", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "success ok cool.nzb 42" {
+		t.Fatalf("unexpected hook output: %q", got)
+	}
+}
+
+func TestHookRenderer(t *testing.T) {
+	release := make(chan struct{})
+	var files []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var e HookEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		files = append(files, e.File)
+	}))
+	defer ts.Close()
+
+	defer func(h *Hooks) { hooks = h }(hooks)
+	hooks = NewHooks(ConfigHooks{URL: ts.URL})
+
+	r := newHookRenderer(nullRenderer{})
+	start := time.Now()
+	for _, name := range []string{"a", "b", "c"} {
+		r.Render(&ProgressEvent{Event: "file_done", File: &FileProgress{Name: name}})
+	}
+	r.Render(&ProgressEvent{Event: "progress"})
+	// The webhook is still blocked, rendering mustn't wait for it
+	if time.Since(start) > time.Second {
+		t.Errorf("rendering waited for the hooks")
+	}
+	close(release)
+	r.Wait()
+	if strings.Join(files, ",") != "a,b,c" {
+		t.Errorf("got hooks for %q", files)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A tiny Prometheus text format registry, just enough for the handful of
+// metrics we export without pulling in the whole client library.
+
+var metrics = NewMetricsRegistry()
+
+var (
+	metricPostedBytes = metrics.Counter("gopoststuff_posted_bytes_total",
+		"Article bytes posted.", "server")
+	metricArticlesPosted = metrics.Counter("gopoststuff_articles_posted_total",
+		"Articles posted successfully.", "server")
+	metricArticlesFailed = metrics.Counter("gopoststuff_articles_failed_total",
+		"Articles that failed to post.", "server")
+	metricArticlesRetried = metrics.Counter("gopoststuff_articles_retried_total",
+		"Article post attempts that were retried.", "server")
+	metricResponses = metrics.Counter("gopoststuff_nntp_responses_total",
+		"NNTP response codes received while posting.", "server", "code")
+	metricConnections = metrics.Gauge("gopoststuff_active_connections",
+		"Currently open NNTP connections.", "server")
+	metricQueueDepth = metrics.Gauge("gopoststuff_article_queue_depth",
+		"Articles generated but not yet picked up by a connection.", "server")
+	metricPostDuration = metrics.Histogram("gopoststuff_post_duration_seconds",
+		"Time taken to POST a single article.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "server")
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type MetricsRegistry struct {
+	families []*metricVec
+	sync.Mutex
+}
+
+type metricVec struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	values  map[string]float64
+	funcs   map[string]func() float64
+	hists   map[string]*histogram
+	reg     *MetricsRegistry
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+func (r *MetricsRegistry) add(name, help, typ string, buckets []float64, labels []string) *metricVec {
+	r.Lock()
+	defer r.Unlock()
+
+	m := &metricVec{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]float64),
+		funcs:   make(map[string]func() float64),
+		hists:   make(map[string]*histogram),
+		reg:     r,
+	}
+	r.families = append(r.families, m)
+	return m
+}
+
+// Counter registers a counter with the given label names
+func (r *MetricsRegistry) Counter(name, help string, labels ...string) *metricVec {
+	return r.add(name, help, "counter", nil, labels)
+}
+
+// Gauge registers a gauge with the given label names
+func (r *MetricsRegistry) Gauge(name, help string, labels ...string) *metricVec {
+	return r.add(name, help, "gauge", nil, labels)
+}
+
+// Histogram registers a histogram with the given upper bucket bounds and label names
+func (r *MetricsRegistry) Histogram(name, help string, buckets []float64, labels ...string) *metricVec {
+	return r.add(name, help, "histogram", buckets, labels)
+}
+
+// labelString formats label values as {a="x",b="y"}
+func (m *metricVec) labelString(values []string) string {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s wants %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+	pairs := make([]string, 0, len(values))
+	for i, v := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", m.labels[i], labelEscaper.Replace(v)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Add adds v to the counter or gauge with the given label values
+func (m *metricVec) Add(v float64, labels ...string) {
+	key := m.labelString(labels)
+	m.reg.Lock()
+	m.values[key] += v
+	m.reg.Unlock()
+}
+
+// Inc adds 1 to the counter or gauge with the given label values
+func (m *metricVec) Inc(labels ...string) {
+	m.Add(1, labels...)
+}
+
+// Set sets the gauge with the given label values
+func (m *metricVec) Set(v float64, labels ...string) {
+	key := m.labelString(labels)
+	m.reg.Lock()
+	m.values[key] = v
+	m.reg.Unlock()
+}
+
+// SetFunc makes the gauge with the given label values call f when scraped
+func (m *metricVec) SetFunc(f func() float64, labels ...string) {
+	key := m.labelString(labels)
+	m.reg.Lock()
+	m.funcs[key] = f
+	m.reg.Unlock()
+}
+
+// Observe records v in the histogram with the given label values
+func (m *metricVec) Observe(v float64, labels ...string) {
+	key := m.labelString(labels)
+	m.reg.Lock()
+	defer m.reg.Unlock()
+
+	h, ok := m.hists[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.hists[key] = h
+	}
+	for i, b := range m.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var sb strings.Builder
+	for _, m := range r.families {
+		fmt.Fprintf(&sb, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", m.name, m.typ)
+
+		if m.typ == "histogram" {
+			for _, key := range sortedKeys(m.hists) {
+				h := m.hists[key]
+				labels := strings.TrimSuffix(strings.TrimPrefix(key, "{"), "}")
+				if len(labels) > 0 {
+					labels += ","
+				}
+				for i, b := range m.buckets {
+					fmt.Fprintf(&sb, "%s_bucket{%sle=\"%s\"} %d\n", m.name, labels, formatFloat(b), h.counts[i])
+				}
+				fmt.Fprintf(&sb, "%s_bucket{%sle=\"+Inf\"} %d\n", m.name, labels, h.count)
+				fmt.Fprintf(&sb, "%s_sum%s %s\n", m.name, key, formatFloat(h.sum))
+				fmt.Fprintf(&sb, "%s_count%s %d\n", m.name, key, h.count)
+			}
+			continue
+		}
+
+		values := make(map[string]float64, len(m.values)+len(m.funcs))
+		for key, v := range m.values {
+			values[key] = v
+		}
+		for key, f := range m.funcs {
+			values[key] = f()
+		}
+		for _, key := range sortedKeys(values) {
+			fmt.Fprintf(&sb, "%s%s %s\n", m.name, key, formatFloat(values[key]))
+		}
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// ServeHTTP makes the registry usable as a /metrics handler
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// StartMetricsServer serves the metrics on addr in the background
+func StartMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		log.Info("Serving metrics on http://%s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fatalf("Metrics server error: %s", err)
+		}
+	}()
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]float64:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
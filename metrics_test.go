@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	r := NewMetricsRegistry()
+	counter := r.Counter("test_posted_total", "Articles posted.", "server")
+	responses := r.Counter("test_responses_total", "Responses.", "server", "code")
+	gauge := r.Gauge("test_connections", "Open connections.", "server")
+	queue := r.Gauge("test_queue_depth", "Queued articles.", "server")
+	hist := r.Histogram("test_duration_seconds", "Post duration.", []float64{0.5, 1}, "server")
+
+	counter.Inc("b")
+	counter.Add(2, "a")
+	counter.Inc("a")
+	responses.Inc("a", "240")
+	responses.Inc(`we"ird\`, "441")
+	gauge.Inc("a")
+	gauge.Inc("a")
+	gauge.Add(-1, "a")
+	gauge.Set(5, "b")
+	queue.SetFunc(func() float64 { return 7 }, "a")
+	hist.Observe(0.25, "a")
+	hist.Observe(0.75, "a")
+	hist.Observe(3, "a")
+
+	want := `# HELP test_posted_total Articles posted.
+# TYPE test_posted_total counter
+test_posted_total{server="a"} 3
+test_posted_total{server="b"} 1
+# HELP test_responses_total Responses.
+# TYPE test_responses_total counter
+test_responses_total{server="a",code="240"} 1
+test_responses_total{server="we\"ird\\",code="441"} 1
+# HELP test_connections Open connections.
+# TYPE test_connections gauge
+test_connections{server="a"} 1
+test_connections{server="b"} 5
+# HELP test_queue_depth Queued articles.
+# TYPE test_queue_depth gauge
+test_queue_depth{server="a"} 7
+# HELP test_duration_seconds Post duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{server="a",le="0.5"} 1
+test_duration_seconds_bucket{server="a",le="1"} 2
+test_duration_seconds_bucket{server="a",le="+Inf"} 3
+test_duration_seconds_sum{server="a"} 4
+test_duration_seconds_count{server="a"} 3
+`
+	var sb strings.Builder
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %s", ct)
+	}
+	if w.Body.String() != want {
+		t.Errorf("served metrics differ from WriteTo")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for missing label values")
+		}
+	}()
+	responses.Inc("a")
+}
@@ -34,7 +34,7 @@ type Nzb struct {
 
 type Meta struct {
 	Type  string `xml:"type,attr"`
-	Value string `xml:",innerxml"`
+	Value string `xml:",chardata"`
 }
 
 type NzbFile struct {
@@ -58,14 +58,24 @@ func CreateNzb(filename string, nzb *Nzb) error {
 		sort.Sort(nzb.File[i].Segments)
 	}
 	nzb.XMLns = "http://www.newzbin.com/DTD/2003/nzb"
-	if output, err := xml.MarshalIndent(nzb, "", "    "); err == nil {
-		output = []byte(NzbHeader + NzbDoctype + string(output))
-		err := ioutil.WriteFile(filename, output, 0755)
-		if err != nil {
-			return err
+	output, err := xml.MarshalIndent(nzb, "", "    ")
+	if err != nil {
+		return err
+	}
+	output = []byte(NzbHeader + NzbDoctype + string(output))
+	return ioutil.WriteFile(filename, output, 0755)
+}
+
+// setPassword sets the password meta, replacing one that is already there.
+// Downloaders only look at a single password.
+func setPassword(meta []Meta, password string) []Meta {
+	for i := range meta {
+		if meta[i].Type == "password" {
+			meta[i].Value = password
+			return meta
 		}
 	}
-	return nil
+	return append(meta, Meta{Type: "password", Value: password})
 }
 
 func SafeFileName(str string) string {
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNzbPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gps-nzb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	meta := setPassword(nil, "first")
+	meta = setPassword(append(meta, Meta{Type: "title", Value: "t"}), `p<a>ss&"word'`)
+	if len(meta) != 2 || meta[0].Value != `p<a>ss&"word'` {
+		t.Fatalf("got meta %+v", meta)
+	}
+
+	path := filepath.Join(dir, "test.nzb")
+	if err := CreateNzb(path, &Nzb{Head: meta}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Nzb
+	if err := xml.Unmarshal(raw, &parsed); err != nil {
+		t.Fatalf("nzb doesn't parse: %s\n%s", err, raw)
+	}
+	if len(parsed.Head) != 2 || parsed.Head[0] != meta[0] || parsed.Head[1] != meta[1] {
+		t.Errorf("meta came back as %+v", parsed.Head)
+	}
+}
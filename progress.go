@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress keeps track of how far along posting is. It is updated by the
+// connection goroutines and rendered periodically by StatusLogger.
+type Progress struct {
+	start   time.Time
+	files   map[string]*FileProgress
+	order   []string
+	servers map[string]*ServerProgress
+	done    []*FileProgress
+	speed   float64
+	sync.Mutex
+}
+
+// FileProgress is the progress of a single file across all servers
+type FileProgress struct {
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	Total    int64   `json:"total"`
+	Posted   int64   `json:"posted"`
+	Percent  float64 `json:"percent"`
+	Articles int64   `json:"articles"`
+	Failed   int64   `json:"failed"`
+}
+
+// ServerProgress is the progress of everything posted to a single server
+type ServerProgress struct {
+	Name     string  `json:"name"`
+	Total    int64   `json:"total"`
+	Posted   int64   `json:"posted"`
+	Percent  float64 `json:"percent"`
+	Articles int64   `json:"articles"`
+	Failed   int64   `json:"failed"`
+}
+
+// ProgressEvent is a snapshot of the progress handed to a ProgressRenderer.
+// Event is one of "start", "progress", "file_done" or "finish".
+type ProgressEvent struct {
+	Event          string            `json:"event"`
+	Time           int64             `json:"time"`
+	Elapsed        float64           `json:"elapsed"`
+	TotalBytes     int64             `json:"total_bytes"`
+	PostedBytes    int64             `json:"posted_bytes"`
+	Percent        float64           `json:"percent"`
+	Speed          float64           `json:"speed"`
+	ETA            float64           `json:"eta"`
+	ArticlesDone   int64             `json:"articles_done"`
+	ArticlesFailed int64             `json:"articles_failed"`
+	File           *FileProgress     `json:"file,omitempty"`
+	Files          []*FileProgress   `json:"files,omitempty"`
+	Servers        []*ServerProgress `json:"servers,omitempty"`
+}
+
+func NewProgress() *Progress {
+	return &Progress{
+		start:   time.Now(),
+		files:   make(map[string]*FileProgress),
+		servers: make(map[string]*ServerProgress),
+	}
+}
+
+// AddFile registers a file of size bytes that will be posted to every server
+func (p *Progress) AddFile(name string, size int64) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.files[name]; !ok {
+		p.order = append(p.order, name)
+		p.files[name] = &FileProgress{Name: name}
+	}
+	p.files[name].Size += size
+}
+
+// AddServer registers a server that all files will be posted to
+func (p *Progress) AddServer(name string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.servers[name] = &ServerProgress{Name: name}
+}
+
+// Start resets the start time and works out the totals, call it after all
+// files and servers have been added.
+func (p *Progress) Start() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.start = time.Now()
+	var size int64
+	for _, fp := range p.files {
+		fp.Total = fp.Size * int64(len(p.servers))
+		size += fp.Size
+	}
+	for _, sp := range p.servers {
+		sp.Total = size
+	}
+}
+
+// ArticleDone records a successfully posted article of bytes raw file bytes
+func (p *Progress) ArticleDone(server, file string, bytes int64) {
+	p.Lock()
+	defer p.Unlock()
+
+	if sp, ok := p.servers[server]; ok {
+		sp.Posted += bytes
+		sp.Articles++
+	}
+	if fp, ok := p.files[file]; ok {
+		fp.Posted += bytes
+		fp.Articles++
+		if fp.Posted == fp.Total {
+			p.done = append(p.done, fp.copy())
+		}
+	}
+}
+
+// ArticleFailed records an article that could not be posted
+func (p *Progress) ArticleFailed(server, file string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if sp, ok := p.servers[server]; ok {
+		sp.Failed++
+	}
+	if fp, ok := p.files[file]; ok {
+		fp.Failed++
+	}
+}
+
+// SetSpeed stores the current speed in bytes per second
+func (p *Progress) SetSpeed(speed float64) {
+	p.Lock()
+	p.speed = speed
+	p.Unlock()
+}
+
+// Snapshot returns the current state as an event of the given type
+func (p *Progress) Snapshot(event string) *ProgressEvent {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	e := &ProgressEvent{
+		Event:   event,
+		Time:    now.Unix(),
+		Elapsed: now.Sub(p.start).Seconds(),
+		Speed:   p.speed,
+	}
+
+	for _, name := range p.order {
+		fp := p.files[name]
+		e.TotalBytes += fp.Total
+		e.PostedBytes += fp.Posted
+		// Only include files that are currently being posted
+		if fp.Posted > 0 && fp.Posted < fp.Total {
+			e.Files = append(e.Files, fp.copy())
+		}
+	}
+
+	names := make([]string, 0, len(p.servers))
+	for name := range p.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sp := *p.servers[name]
+		sp.Percent = percent(sp.Posted, sp.Total)
+		e.ArticlesDone += sp.Articles
+		e.ArticlesFailed += sp.Failed
+		e.Servers = append(e.Servers, &sp)
+	}
+
+	e.Percent = percent(e.PostedBytes, e.TotalBytes)
+	if e.PostedBytes > 0 && e.Elapsed > 0 {
+		rate := float64(e.PostedBytes) / e.Elapsed
+		e.ETA = float64(e.TotalBytes-e.PostedBytes) / rate
+	}
+	return e
+}
+
+// FilesDone returns the files that finished since the last call
+func (p *Progress) FilesDone() []*FileProgress {
+	p.Lock()
+	defer p.Unlock()
+
+	done := p.done
+	p.done = nil
+	return done
+}
+
+func (fp *FileProgress) copy() *FileProgress {
+	c := *fp
+	c.Percent = percent(c.Posted, c.Total)
+	return &c
+}
+
+func percent(a, b int64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b) * 100
+}
+
+// A ProgressRenderer displays progress events somewhere
+type ProgressRenderer interface {
+	Render(e *ProgressEvent)
+}
+
+// NewProgressRenderer returns the renderer for mode, one of "auto", "tty",
+// "log", "json" or "none". JSON events are written to file descriptor fd.
+func NewProgressRenderer(mode string, interval time.Duration, fd int) (ProgressRenderer, error) {
+	switch strings.ToLower(mode) {
+	case "", "auto":
+		if isTerminal(os.Stdout) {
+			return &ttyRenderer{w: os.Stdout}, nil
+		}
+		return &logRenderer{interval: interval}, nil
+	case "tty":
+		return &ttyRenderer{w: os.Stdout}, nil
+	case "log":
+		return &logRenderer{interval: interval}, nil
+	case "json":
+		return &jsonRenderer{enc: json.NewEncoder(os.NewFile(uintptr(fd), "progress")), interval: interval}, nil
+	case "none":
+		return nullRenderer{}, nil
+	}
+	return nil, fmt.Errorf("Unknown progress mode '%s'", mode)
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return st.Mode()&os.ModeCharDevice != 0
+}
+
+// ttyRenderer draws a progress bar on a terminal
+type ttyRenderer struct {
+	w io.Writer
+}
+
+const ttyBarWidth = 30
+
+func (r *ttyRenderer) Render(e *ProgressEvent) {
+	switch e.Event {
+	case "file_done":
+		fmt.Fprintf(r.w, "\r\033[KFinished %s\n", e.File.Name)
+	case "progress", "finish":
+		filled := int(e.Percent / 100 * ttyBarWidth)
+		if filled > ttyBarWidth {
+			filled = ttyBarWidth
+		}
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", ttyBarWidth-filled)
+		speed, speedUnit := prettySize(e.Speed)
+		fmt.Fprintf(r.w, "\r\033[K[%s] \033[1m%5.1f%%\033[0m %.1f/%.1fMiB - %.1f%s/s - ETA %s",
+			bar, e.Percent, float64(e.PostedBytes)/1024/1024, float64(e.TotalBytes)/1024/1024,
+			speed, speedUnit, formatETA(e.ETA))
+		if e.Event == "finish" {
+			fmt.Fprintln(r.w)
+		}
+	}
+}
+
+// logRenderer writes plain progress lines to the log every interval
+type logRenderer struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func (r *logRenderer) Render(e *ProgressEvent) {
+	switch e.Event {
+	case "file_done":
+		log.Info("Finished %s (%d articles)", e.File.Name, e.File.Articles)
+	case "progress":
+		if time.Since(r.last) < r.interval {
+			return
+		}
+		r.last = time.Now()
+		speed, speedUnit := prettySize(e.Speed)
+		log.Info("Posted %.1f%% (%.1f/%.1fMiB) at %.1f%s/s, %d article(s) done, %d failed, ETA %s",
+			e.Percent, float64(e.PostedBytes)/1024/1024, float64(e.TotalBytes)/1024/1024,
+			speed, speedUnit, e.ArticlesDone, e.ArticlesFailed, formatETA(e.ETA))
+	}
+}
+
+// jsonRenderer writes newline delimited JSON events
+type jsonRenderer struct {
+	enc      *json.Encoder
+	interval time.Duration
+	last     time.Time
+}
+
+func (r *jsonRenderer) Render(e *ProgressEvent) {
+	if e.Event == "progress" {
+		if time.Since(r.last) < r.interval {
+			return
+		}
+		r.last = time.Now()
+	}
+	if err := r.enc.Encode(e); err != nil {
+		log.Warning("Error while writing progress: %s", err)
+	}
+}
+
+type nullRenderer struct{}
+
+func (nullRenderer) Render(e *ProgressEvent) {}
+
+func formatETA(secs float64) string {
+	if secs <= 0 {
+		return "-"
+	}
+	return (time.Duration(secs) * time.Second).String()
+}
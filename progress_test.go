@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	p := NewProgress()
+	p.AddFile("a", 100)
+	p.AddFile("a", 50)
+	p.AddFile("b", 10)
+	p.AddServer("s1")
+	p.AddServer("s2")
+	p.Start()
+
+	p.ArticleDone("s1", "a", 100)
+	p.ArticleDone("s2", "a", 50)
+	p.ArticleFailed("s2", "b")
+	p.SetSpeed(42)
+
+	e := p.Snapshot("progress")
+	if e.Event != "progress" || e.TotalBytes != 320 || e.PostedBytes != 150 || e.Speed != 42 {
+		t.Errorf("unexpected totals %+v", e)
+	}
+	if e.ArticlesDone != 2 || e.ArticlesFailed != 1 {
+		t.Errorf("expected 2 done and 1 failed, got %d and %d", e.ArticlesDone, e.ArticlesFailed)
+	}
+	if len(e.Files) != 1 || e.Files[0].Name != "a" || e.Files[0].Total != 300 || e.Files[0].Percent != 50 {
+		t.Errorf("unexpected files %+v", e.Files)
+	}
+	if len(e.Servers) != 2 || e.Servers[0].Name != "s1" || e.Servers[0].Total != 160 || e.Servers[1].Failed != 1 {
+		t.Errorf("unexpected servers %+v", e.Servers)
+	}
+	if e.ETA <= 0 {
+		t.Errorf("expected an ETA, got %v", e.ETA)
+	}
+	if done := p.FilesDone(); len(done) != 0 {
+		t.Errorf("expected no finished files, got %+v", done)
+	}
+
+	p.ArticleDone("s1", "a", 50)
+	p.ArticleDone("s2", "a", 100)
+	done := p.FilesDone()
+	if len(done) != 1 || done[0].Name != "a" || done[0].Articles != 4 || done[0].Percent != 100 {
+		t.Errorf("unexpected finished files %+v", done)
+	}
+	if done := p.FilesDone(); len(done) != 0 {
+		t.Errorf("finished files were returned twice: %+v", done)
+	}
+
+	// Unknown names are ignored
+	p.ArticleDone("s3", "c", 10)
+	p.ArticleFailed("s3", "c")
+	if e := p.Snapshot("finish"); e.PostedBytes != 300 || e.Percent != 300.0/320*100 || len(e.Files) != 0 {
+		t.Errorf("unexpected final snapshot %+v", e)
+	}
+}
+
+func TestProgressRenderers(t *testing.T) {
+	for _, mode := range []string{"", "auto", "tty", "LOG", "none"} {
+		if _, err := NewProgressRenderer(mode, time.Second, 1); err != nil {
+			t.Errorf("%q: %s", mode, err)
+		}
+	}
+	if _, err := NewProgressRenderer("fancy", time.Second, 1); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+
+	var buf bytes.Buffer
+	tty := &ttyRenderer{w: &buf}
+	tty.Render(&ProgressEvent{Event: "progress", Percent: 50, PostedBytes: 1 << 20, TotalBytes: 2 << 20, Speed: 2048, ETA: 90})
+	if out := buf.String(); !strings.Contains(out, "["+strings.Repeat("=", 15)+strings.Repeat(" ", 15)+"]") ||
+		!strings.Contains(out, " 50.0%") || !strings.Contains(out, "1.0/2.0MiB - 2.0KB/s - ETA 1m30s") || strings.HasSuffix(out, "\n") {
+		t.Errorf("unexpected progress line %q", out)
+	}
+	buf.Reset()
+	tty.Render(&ProgressEvent{Event: "finish", Percent: 120})
+	if out := buf.String(); !strings.Contains(out, strings.Repeat("=", ttyBarWidth)) || !strings.HasSuffix(out, "ETA -\n") {
+		t.Errorf("unexpected finish line %q", out)
+	}
+	buf.Reset()
+	tty.Render(&ProgressEvent{Event: "file_done", File: &FileProgress{Name: "a.bin"}})
+	if out := buf.String(); !strings.HasSuffix(out, "Finished a.bin\n") {
+		t.Errorf("unexpected file_done line %q", out)
+	}
+
+	// Progress events are throttled, the others are always written
+	buf.Reset()
+	r := &jsonRenderer{enc: json.NewEncoder(&buf), interval: time.Hour}
+	for _, event := range []string{"start", "progress", "progress", "file_done", "finish"} {
+		r.Render(&ProgressEvent{Event: event})
+	}
+	var events []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e ProgressEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e.Event)
+	}
+	if strings.Join(events, ",") != "start,progress,file_done,finish" {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestFormatETA(t *testing.T) {
+	for secs, want := range map[float64]string{0: "-", -5: "-", 59: "59s", 3661: "1h1m1s"} {
+		if got := formatETA(secs); got != want {
+			t.Errorf("%v: got %s, want %s", secs, got, want)
+		}
+	}
+}
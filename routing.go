@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A GroupRule routes files matching a pattern to a list of newsgroups.
+// Patterns are globs matched against the file name, "dir:GLOB" patterns are
+// matched against the name of the input directory the file was found in.
+type GroupRule struct {
+	pattern string
+	dir     bool
+	groups  string
+}
+
+// ParseGroupRules parses rules of the form "PATTERN group1,group2"
+func ParseGroupRules(rules []string) ([]GroupRule, error) {
+	parsed := make([]GroupRule, 0, len(rules))
+	for _, rule := range rules {
+		fields := strings.SplitN(strings.TrimSpace(rule), " ", 2)
+		groups := ""
+		if len(fields) == 2 {
+			groups = normalizeGroups(fields[1])
+		}
+		if len(groups) == 0 || strings.ContainsAny(groups, " \t") {
+			return nil, fmt.Errorf("Invalid group rule '%s', want \"PATTERN group1,group2\"", rule)
+		}
+
+		gr := GroupRule{pattern: fields[0], groups: groups}
+		if strings.HasPrefix(gr.pattern, "dir:") {
+			gr.dir = true
+			gr.pattern = gr.pattern[4:]
+		} else if strings.HasPrefix(gr.pattern, ".") {
+			// Plain extensions are shorthand for *.ext
+			gr.pattern = "*" + gr.pattern
+		}
+		if _, err := filepath.Match(gr.pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid group rule pattern '%s': %s", fields[0], err)
+		}
+		parsed = append(parsed, gr)
+	}
+	return parsed, nil
+}
+
+// Matches reports whether fd should be posted to the rule's groups. Volumes
+// also match on the name of the file they came from, archives on the name of
+// any file in them.
+func (gr GroupRule) Matches(fd FileData) bool {
+	if gr.dir {
+		ok, _ := filepath.Match(gr.pattern, filepath.Base(fd.input))
+		return ok
+	}
+	names := append([]string{fd.name, filepath.Base(fd.path)}, fd.sources...)
+	for _, name := range names {
+		if ok, _ := filepath.Match(strings.ToLower(gr.pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteGroups sets the groups of every file from the first matching rule,
+// falling back to defaultGroups.
+func RouteGroups(files []FileData, rules []GroupRule, defaultGroups string) {
+	defaultGroups = normalizeGroups(defaultGroups)
+	for i := range files {
+		files[i].groups = defaultGroups
+		for _, gr := range rules {
+			if gr.Matches(files[i]) {
+				files[i].groups = gr.groups
+				break
+			}
+		}
+		log.Debug("Posting %s to %s", files[i].name, files[i].groups)
+	}
+}
+
+// CheckCrosspost makes sure none of the group lists files can be posted to
+// has more groups than any of the servers allow.
+func CheckCrosspost(groupLists []string, servers map[string]*ConfigServer) error {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var bad []string
+	for _, name := range names {
+		server := servers[name]
+		if server.MaxCrosspost <= 0 {
+			continue
+		}
+		for _, groups := range groupLists {
+			if n := len(strings.Split(normalizeGroups(groups), ",")); n > server.MaxCrosspost {
+				bad = append(bad, fmt.Sprintf("%s (%d groups, %s allows %d)", groups, n, name, server.MaxCrosspost))
+			}
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("Too many groups for: %s", strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+// normalizeGroups tidies up a comma separated list of groups
+func normalizeGroups(groups string) string {
+	var out []string
+	for _, g := range strings.Split(groups, ",") {
+		g = strings.TrimSpace(g)
+		if len(g) > 0 {
+			out = append(out, g)
+		}
+	}
+	return strings.Join(out, ",")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteGroups(t *testing.T) {
+	rules, err := ParseGroupRules([]string{
+		".par2 alt.binaries.test.par2",
+		"dir:Linux* alt.binaries.linux, alt.binaries.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []FileData{
+		{path: "/in/Cool Files/cool.rar", name: "cool.rar", input: "Cool Files"},
+		{path: "/in/Cool Files/cool.vol0+1.PAR2", name: "cool.vol0+1.PAR2", input: "Cool Files"},
+		{path: "/in/Linux ISOs/distro.iso", name: "distro.iso.001", input: "/in/Linux ISOs"},
+		{path: "/in/Linux ISOs/distro.par2", name: "distro.par2", input: "/in/Linux ISOs"},
+		{path: "/tmp/archive/0/cool.zip", name: "cool.zip", input: "Cool Files", sources: []string{"cool.nfo", "cool.PAR2"}},
+		{path: "/tmp/archive/0/cool.zip", name: "cool.zip.001", input: "Cool Files", sources: []string{"cool.nfo", "cool.PAR2"}},
+		{path: "/tmp/archive/1/other.zip", name: "other.zip", input: "Cool Files", sources: []string{"other.mkv"}},
+	}
+	RouteGroups(files, rules, "alt.binaries.test, alt.binaries.test.yenc")
+
+	expected := []string{
+		"alt.binaries.test,alt.binaries.test.yenc",
+		"alt.binaries.test.par2",
+		"alt.binaries.linux,alt.binaries.test",
+		"alt.binaries.test.par2",
+		"alt.binaries.test.par2",
+		"alt.binaries.test.par2",
+		"alt.binaries.test,alt.binaries.test.yenc",
+	}
+	for i, fd := range files {
+		if fd.groups != expected[i] {
+			t.Errorf("%s: expected %q, got %q", fd.name, expected[i], fd.groups)
+		}
+	}
+}
+
+func TestParseGroupRulesErrors(t *testing.T) {
+	for _, rule := range []string{"*.par2", "[ alt.binaries.test", "*.nfo ,", "a b c"} {
+		if _, err := ParseGroupRules([]string{rule}); err == nil {
+			t.Errorf("expected an error for %q", rule)
+		}
+	}
+}
+
+func TestCheckCrosspost(t *testing.T) {
+	groupLists := []string{"a.b.c, a.b.d", "a.b.c,a.b.d,a.b.e"}
+	servers := map[string]*ConfigServer{
+		"pants": {MaxCrosspost: 2},
+		"socks": {},
+	}
+	err := CheckCrosspost(groupLists, servers)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "a.b.c,a.b.d,a.b.e (3 groups, pants allows 2)") || strings.Contains(err.Error(), "a.b.c, a.b.d") {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	servers["pants"].MaxCrosspost = 3
+	if err := CheckCrosspost(groupLists, servers); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
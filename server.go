@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+)
+
+// TLSMode works out what the TLS setting means: on/yes/true for TLS straight
+// away, starttls to upgrade the connection after connecting, starttls-optional
+// to only upgrade it if the server offers STARTTLS, or off. TLS used to be a
+// boolean, so true and false keep working.
+func (s *ConfigServer) TLSMode() (simplenntp.TLSMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s.TLS)) {
+	case "on", "yes", "true", "1":
+		return simplenntp.ImplicitTLS, nil
+	case "", "off", "no", "false", "0":
+		return simplenntp.NoTLS, nil
+	case "starttls":
+		return simplenntp.StartTLS, nil
+	case "starttls-optional":
+		return simplenntp.OptionalStartTLS, nil
+	}
+	return simplenntp.NoTLS, fmt.Errorf("Invalid TLS value '%s', want on, off, starttls or starttls-optional", s.TLS)
+}
+
+// TLSOptions collects the TLS settings for simplenntp.Dial
+func (s *ConfigServer) TLSOptions() (*simplenntp.TLSOptions, error) {
+	opts := &simplenntp.TLSOptions{
+		InsecureSkipVerify: s.InsecureSSL,
+		ServerName:         s.ServerName,
+		CAFile:             s.CAFile,
+		ClientCert:         s.ClientCert,
+		ClientKey:          s.ClientKey,
+		PinSHA256:          s.PinSHA256,
+	}
+
+	switch strings.TrimSpace(s.MinTLSVersion) {
+	case "":
+	case "1.0":
+		opts.MinVersion = tls.VersionTLS10
+	case "1.1":
+		opts.MinVersion = tls.VersionTLS11
+	case "1.2":
+		opts.MinVersion = tls.VersionTLS12
+	case "1.3":
+		opts.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("Invalid MinTLSVersion '%s', want 1.0, 1.1, 1.2 or 1.3", s.MinTLSVersion)
+	}
+
+	if (len(s.ClientCert) > 0) != (len(s.ClientKey) > 0) {
+		return nil, fmt.Errorf("ClientCert and ClientKey need to be set together")
+	}
+	return opts, nil
+}
+
+// Dialer returns the NetDialer for simplenntp.Dial, nil to connect directly.
+// Connections are spread over the bind addresses by connID.
+func (s *ConfigServer) Dialer(connID int) (simplenntp.NetDialer, error) {
+	var dialer simplenntp.NetDialer = &net.Dialer{}
+	if len(s.BindAddress) > 0 {
+		i := connID - 1
+		if i < 0 {
+			i = 0
+		}
+		var err error
+		if dialer, err = simplenntp.BindDialer(s.BindAddress[i%len(s.BindAddress)]); err != nil {
+			return nil, err
+		}
+	} else if len(s.Proxy) == 0 {
+		return nil, nil
+	}
+
+	if len(s.Proxy) > 0 {
+		return simplenntp.ProxyDialer(s.Proxy, dialer)
+	}
+	return dialer, nil
+}
+
+// Timeouts converts the timeout settings in seconds
+func (s *ConfigServer) Timeouts() simplenntp.Timeouts {
+	return simplenntp.Timeouts{
+		Connect: time.Duration(s.ConnectTimeout) * time.Second,
+		Read:    time.Duration(s.ReadTimeout) * time.Second,
+		Write:   time.Duration(s.WriteTimeout) * time.Second,
+		Idle:    time.Duration(s.IdleTimeout) * time.Second,
+	}
+}
+
+// CheckServerConfig makes sure every server definition makes sense
+func CheckServerConfig(servers map[string]*ConfigServer) error {
+	for name, server := range servers {
+		mode, err := server.TLSMode()
+		if err != nil {
+			return fmt.Errorf("Server '%s': %s", name, err)
+		}
+		switch strings.ToLower(server.AuthMethod) {
+		case "", "user", "sasl", "auto":
+		default:
+			return fmt.Errorf("Server '%s': Invalid AuthMethod '%s', want user, sasl or auto", name, server.AuthMethod)
+		}
+		opts, err := server.TLSOptions()
+		if err != nil {
+			return fmt.Errorf("Server '%s': %s", name, err)
+		}
+		for i := range server.BindAddress {
+			if _, err := server.Dialer(i + 1); err != nil {
+				return fmt.Errorf("Server '%s': %s", name, err)
+			}
+		}
+		if _, err := server.Dialer(0); err != nil {
+			return fmt.Errorf("Server '%s': %s", name, err)
+		}
+		// Load the certificates now rather than when connecting
+		if mode != simplenntp.NoTLS {
+			if _, err := opts.Config(server.Address); err != nil {
+				return fmt.Errorf("Server '%s': %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// connectContext returns a context that ends after the connect timeout of
+// server, which covers dialing, TLS and logging in.
+func connectContext(server *ConfigServer) (context.Context, context.CancelFunc) {
+	timeout := server.Timeouts().Connect
+	if timeout == 0 {
+		timeout = simplenntp.DefaultTimeouts.Connect
+	}
+	if timeout < 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// dialServer makes connection connID to server with its configured settings
+func dialServer(ctx context.Context, server *ConfigServer, connID int, tdchan chan *TimeData) (*simplenntp.Conn, error) {
+	mode, err := server.TLSMode()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := server.TLSOptions()
+	if err != nil {
+		return nil, err
+	}
+	dialer, err := server.Dialer(connID)
+	if err != nil {
+		return nil, err
+	}
+	options := simplenntp.Options{
+		Address:    server.Address,
+		Port:       server.Port,
+		TLS:        mode,
+		TLSOptions: opts,
+		Dialer:     dialer,
+		Timeouts:   server.Timeouts(),
+	}
+	if tdchan != nil {
+		// Write a data sent time point to our channel
+		options.Progress = func(n int) {
+			tdchan <- &TimeData{
+				Milliseconds: time.Now().UnixNano() / 1e6,
+				Bytes:        n,
+			}
+		}
+	}
+	conn, err := simplenntp.DialContext(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	if server.AllowCleartextAuth {
+		conn.AllowCleartextAuth = true
+	}
+	return conn, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+	"gopkg.in/gcfg.v1"
+)
+
+func TestTLSMode(t *testing.T) {
+	for value, expected := range map[string]simplenntp.TLSMode{
+		"on":                simplenntp.ImplicitTLS,
+		"Yes":               simplenntp.ImplicitTLS,
+		"":                  simplenntp.NoTLS,
+		"off":               simplenntp.NoTLS,
+		"STARTTLS":          simplenntp.StartTLS,
+		"starttls-optional": simplenntp.OptionalStartTLS,
+		"true":              simplenntp.ImplicitTLS,
+		"False":             simplenntp.NoTLS,
+	} {
+		mode, err := (&ConfigServer{TLS: value}).TLSMode()
+		if err != nil || mode != expected {
+			t.Errorf("%q: expected %v, got %v, %v", value, expected, mode, err)
+		}
+	}
+	if _, err := (&ConfigServer{TLS: "maybe"}).TLSMode(); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	// Configs from when TLS was a boolean
+	for value, expected := range map[string]simplenntp.TLSMode{
+		"true":  simplenntp.ImplicitTLS,
+		"false": simplenntp.NoTLS,
+		"on":    simplenntp.ImplicitTLS,
+	} {
+		var cfg struct {
+			Server map[string]*ConfigServer
+		}
+		if err := gcfg.ReadStringInto(&cfg, "[server \"test\"]\nTLS="+value+"\n"); err != nil {
+			t.Errorf("TLS=%s: %s", value, err)
+			continue
+		}
+		if mode, err := cfg.Server["test"].TLSMode(); err != nil || mode != expected {
+			t.Errorf("TLS=%s: expected %v, got %v, %v", value, expected, mode, err)
+		}
+	}
+}
+
+func TestServerDialerBindAddress(t *testing.T) {
+	server := &ConfigServer{BindAddress: []string{"127.0.0.1", "::1"}}
+	for connID, expected := range map[int]string{0: "127.0.0.1", 1: "127.0.0.1", 2: "::1", 3: "127.0.0.1", 4: "::1"} {
+		dialer, err := server.Dialer(connID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nd, ok := dialer.(*net.Dialer)
+		if !ok {
+			t.Fatalf("expected a *net.Dialer, got %T", dialer)
+		}
+		if ip := nd.LocalAddr.(*net.TCPAddr).IP; !ip.Equal(net.ParseIP(expected)) {
+			t.Errorf("connection %d: expected %s, got %s", connID, expected, ip)
+		}
+	}
+
+	if d, err := (&ConfigServer{}).Dialer(1); d != nil || err != nil {
+		t.Errorf("expected no dialer, got %v, %v", d, err)
+	}
+	if err := CheckServerConfig(map[string]*ConfigServer{"pants": {BindAddress: []string{"nonsense"}}}); err == nil {
+		t.Errorf("expected an error for a bad bind address")
+	}
+	if err := CheckServerConfig(map[string]*ConfigServer{"pants": {Proxy: "gopher://x"}}); err == nil {
+		t.Errorf("expected an error for a bad proxy")
+	}
+}
@@ -0,0 +1,50 @@
+package simplenntp
+
+import (
+	"fmt"
+	"net"
+)
+
+// BindDialer returns a NetDialer that makes connections from local, either
+// an IPv4 or IPv6 address or the name of a network interface.
+func BindDialer(local string) (NetDialer, error) {
+	ip := net.ParseIP(local)
+	if ip == nil {
+		var err error
+		if ip, err = interfaceAddr(local); err != nil {
+			return nil, err
+		}
+	}
+	return &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}}, nil
+}
+
+// interfaceAddr returns the first global address of a network interface,
+// preferring IPv4.
+func interfaceAddr(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("bind address '%s' is not an IP address or interface: %s", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var found net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() && !ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if found == nil {
+			found = ipnet.IP
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("interface %s has no usable addresses", name)
+	}
+	return found, nil
+}
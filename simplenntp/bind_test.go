@@ -0,0 +1,41 @@
+package simplenntp
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestBindDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	dialer, err := BindDialer("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := dialer.DialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := c.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("expected a connection from 127.0.0.1, got %s", ip)
+	}
+	c.Close()
+
+	if _, err := BindDialer("no-such-interface0"); err == nil {
+		t.Errorf("expected an error for an unknown interface")
+	}
+}
@@ -0,0 +1,92 @@
+package simplenntp
+
+import (
+	"context"
+	"strings"
+)
+
+// Capabilities is what a server says it supports in its CAPABILITIES
+// response (RFC 3977 section 5.2).
+type Capabilities struct {
+	Version   []string
+	Post      bool
+	IHave     bool
+	Streaming bool
+	StartTLS  bool
+	Reader    bool
+	// Compression algorithms, e.g. DEFLATE
+	Compress []string
+	// AUTHINFO variants, e.g. USER and SASL, and the SASL mechanisms
+	AuthInfo []string
+	SASL     []string
+	// Every capability line keyed by its upper case label
+	Raw map[string][]string
+}
+
+// Has reports whether the server listed capability label
+func (c *Capabilities) Has(label string) bool {
+	_, ok := c.Raw[strings.ToUpper(label)]
+	return ok
+}
+
+// Capabilities asks the server what it supports
+func (c *Conn) Capabilities() (*Capabilities, error) {
+	return c.CapabilitiesContext(context.Background())
+}
+
+// CapabilitiesContext is Capabilities with a context
+func (c *Conn) CapabilitiesContext(ctx context.Context) (*Capabilities, error) {
+	if _, _, err := c.cmd(ctx, StatusCapabilitiesFollow, "CAPABILITIES"); err != nil {
+		return nil, err
+	}
+	lines, err := c.readLines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.Caps = ParseCapabilities(lines)
+	return c.Caps, nil
+}
+
+// ParseCapabilities parses the lines of a CAPABILITIES response
+func ParseCapabilities(lines []string) *Capabilities {
+	caps := &Capabilities{Raw: make(map[string][]string)}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		label := strings.ToUpper(fields[0])
+		args := fields[1:]
+		caps.Raw[label] = args
+
+		switch label {
+		case "VERSION":
+			caps.Version = args
+		case "POST":
+			caps.Post = true
+		case "IHAVE":
+			caps.IHave = true
+		case "STREAMING":
+			caps.Streaming = true
+		case "STARTTLS":
+			caps.StartTLS = true
+		case "READER":
+			caps.Reader = true
+		case "COMPRESS":
+			caps.Compress = upper(args)
+		case "AUTHINFO":
+			caps.AuthInfo = upper(args)
+		case "SASL":
+			caps.SASL = upper(args)
+		}
+	}
+	return caps
+}
+
+func upper(s []string) []string {
+	u := make([]string, len(s))
+	for i := range s {
+		u[i] = strings.ToUpper(s[i])
+	}
+	return u
+}
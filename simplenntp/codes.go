@@ -0,0 +1,63 @@
+package simplenntp
+
+import "fmt"
+
+// StatusCode is the three digit code that starts every response line
+// (RFC 3977 section 3.2).
+type StatusCode uint
+
+// Response codes from RFC 3977, RFC 4642, RFC 4643 and RFC 8054
+const (
+	StatusHelpFollows         StatusCode = 100
+	StatusCapabilitiesFollow  StatusCode = 101
+	StatusDate                StatusCode = 111
+	StatusPostingAllowed      StatusCode = 200
+	StatusPostingProhibited   StatusCode = 201
+	StatusClosing             StatusCode = 205
+	StatusCompressActive      StatusCode = 206
+	StatusGroupSelected       StatusCode = 211
+	StatusListFollows         StatusCode = 215
+	StatusArticleFollows      StatusCode = 220
+	StatusHeadFollows         StatusCode = 221
+	StatusBodyFollows         StatusCode = 222
+	StatusArticleExists       StatusCode = 223
+	StatusOverviewFollows     StatusCode = 224
+	StatusArticlePosted       StatusCode = 240
+	StatusAuthAccepted        StatusCode = 281
+	StatusSASLAccepted        StatusCode = 283
+	StatusSendArticle         StatusCode = 340
+	StatusPasswordRequired    StatusCode = 381
+	StatusStartTLS            StatusCode = 382
+	StatusSASLContinue        StatusCode = 383
+	StatusServiceUnavailable  StatusCode = 400
+	StatusNoSuchGroup         StatusCode = 411
+	StatusNoGroupSelected     StatusCode = 412
+	StatusNoCurrentArticle    StatusCode = 420
+	StatusNoSuchArticleNumber StatusCode = 423
+	StatusNoSuchArticle       StatusCode = 430
+	StatusPostingNotPermitted StatusCode = 440
+	StatusPostingFailed       StatusCode = 441
+	StatusAuthRequired        StatusCode = 480
+	StatusAuthRejected        StatusCode = 481
+	StatusAuthOutOfSequence   StatusCode = 482
+	StatusEncryptionRequired  StatusCode = 483
+	StatusUnknownCommand      StatusCode = 500
+	StatusSyntaxError         StatusCode = 501
+	StatusPermissionDenied    StatusCode = 502
+	StatusNotSupported        StatusCode = 503
+)
+
+func (s StatusCode) String() string {
+	return fmt.Sprintf("%03d", uint(s))
+}
+
+// Temporary reports whether the command may succeed if it is tried again
+// later, which is what 4xx codes mean.
+func (s StatusCode) Temporary() bool {
+	return s/100 == 4
+}
+
+// Permanent reports whether the command failed for good, a 5xx code
+func (s StatusCode) Permanent() bool {
+	return s/100 == 5
+}
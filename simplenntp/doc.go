@@ -0,0 +1,35 @@
+/*
+Package simplenntp is a small NNTP client (RFC 3977) for posting and reading
+articles.
+
+Connect with Dial and the Options for the server, then log in and use the
+commands as methods on the Conn:
+
+	conn, err := simplenntp.Dial(simplenntp.Options{
+		Address: "news.example.com",
+		Port:    563,
+		TLS:     simplenntp.ImplicitTLS,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Quit()
+
+	if err := conn.Authenticate(username, password); err != nil {
+		return err
+	}
+	group, err := conn.Group("alt.binaries.test")
+	...
+	body, err := conn.Body("<part1of10@example.com>")
+
+Every command has a Context variant that gives up when the context is done.
+Errors returned by the server are an Error with the StatusCode of the
+response, anything unexpected from the server is a ProtocolError.
+
+Multi-line responses are returned with dot-stuffing undone. Readers such as
+the one returned by Body are only valid until the next command is sent, the
+rest of the response is skipped then.
+
+A Conn is not safe for concurrent use, open one per goroutine.
+*/
+package simplenntp
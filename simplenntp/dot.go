@@ -0,0 +1,104 @@
+package simplenntp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// dotReader reads the data block of a multi-line response (RFC 3977 section
+// 3.1.1). It undoes dot-stuffing and returns io.EOF at the terminating "."
+// line, line endings are passed on unchanged.
+type dotReader struct {
+	c    *Conn
+	ctx  context.Context
+	line string
+	err  error
+	stop func()
+}
+
+// newDotReader starts reading a data block, which has to be read to the end
+// before the next command can be sent. cmd takes care of that. ctx is
+// watched until the end of the block, so cancelling it interrupts a read.
+func (c *Conn) newDotReader(ctx context.Context) *dotReader {
+	c.dot = &dotReader{c: c, ctx: ctx, stop: c.watch(ctx)}
+	return c.dot
+}
+
+func (d *dotReader) Read(p []byte) (int, error) {
+	for len(d.line) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		line, err := d.readLine()
+		if err != nil {
+			return 0, err
+		}
+		d.line = line
+	}
+	n := copy(p, d.line)
+	d.line = d.line[n:]
+	return n, nil
+}
+
+// readLine returns the next line including its line ending
+func (d *dotReader) readLine() (string, error) {
+	if d.err != nil {
+		return "", d.err
+	}
+	line, err := d.c.readLine(d.ctx)
+	if err == nil {
+		err = d.ctx.Err()
+	}
+	if err != nil {
+		// The connection is out of step now
+		d.c.close = true
+		d.fail(err)
+		return "", err
+	}
+	if line == ".\r\n" || line == ".\n" {
+		d.fail(io.EOF)
+		return "", io.EOF
+	}
+	if strings.HasPrefix(line, "..") {
+		line = line[1:]
+	}
+	return line, nil
+}
+
+// fail makes every following read return err, which ends the data block
+func (d *dotReader) fail(err error) {
+	d.err = err
+	d.line = ""
+	if d.stop != nil {
+		d.stop()
+		d.stop = nil
+	}
+	if d.c.dot == d {
+		d.c.dot = nil
+	}
+}
+
+// discard skips the rest of the data block
+func (d *dotReader) discard() error {
+	_, err := io.Copy(ioutil.Discard, d)
+	return err
+}
+
+// readLines reads a multi-line response up to the terminating "." line,
+// undoing dot-stuffing on the way.
+func (c *Conn) readLines(ctx context.Context) ([]string, error) {
+	d := c.newDotReader(ctx)
+	var lines []string
+	for {
+		line, err := d.readLine()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+}
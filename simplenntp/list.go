@@ -0,0 +1,105 @@
+package simplenntp
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// A Group is a line of a LIST ACTIVE response, or the response to GROUP
+type Group struct {
+	Name   string
+	High   int64
+	Low    int64
+	Status string
+	// Estimated number of articles, only set by Conn.Group
+	Count int64
+}
+
+// Postable reports whether articles may be posted to the group, either
+// directly or by sending them to the moderator.
+func (g Group) Postable() bool {
+	return g.Status == "y" || g.Status == "m"
+}
+
+// ListActive returns the groups matching wildmat, or all of them if wildmat
+// is empty.
+func (c *Conn) ListActive(wildmat string) ([]Group, error) {
+	return c.ListActiveContext(context.Background(), wildmat)
+}
+
+// ListActiveContext is ListActive with a context
+func (c *Conn) ListActiveContext(ctx context.Context, wildmat string) ([]Group, error) {
+	lines, err := c.list(ctx, "ACTIVE", wildmat)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]Group, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, ProtocolError("bad LIST ACTIVE line: " + line)
+		}
+		high, err1 := strconv.ParseInt(fields[1], 10, 64)
+		low, err2 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, ProtocolError("bad LIST ACTIVE line: " + line)
+		}
+		groups = append(groups, Group{Name: fields[0], High: high, Low: low, Status: fields[3]})
+	}
+	return groups, nil
+}
+
+// ListNewsgroups returns the descriptions of the groups matching wildmat, or
+// all of them if wildmat is empty.
+func (c *Conn) ListNewsgroups(wildmat string) (map[string]string, error) {
+	return c.ListNewsgroupsContext(context.Background(), wildmat)
+}
+
+// ListNewsgroupsContext is ListNewsgroups with a context
+func (c *Conn) ListNewsgroupsContext(ctx context.Context, wildmat string) (map[string]string, error) {
+	lines, err := c.list(ctx, "NEWSGROUPS", wildmat)
+	if err != nil {
+		return nil, err
+	}
+
+	descs := make(map[string]string, len(lines))
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) == 1 {
+			fields = strings.SplitN(line, " ", 2)
+		}
+		desc := ""
+		if len(fields) == 2 {
+			desc = strings.TrimSpace(fields[1])
+		}
+		descs[fields[0]] = desc
+	}
+	return descs, nil
+}
+
+// List sends LIST with keyword and its arguments, e.g. List("OVERVIEW.FMT")
+// or List("ACTIVE", "alt.binaries.*"), and returns the lines of the response.
+// An empty keyword sends a plain LIST.
+func (c *Conn) List(keyword string, args ...string) ([]string, error) {
+	return c.ListContext(context.Background(), keyword, args...)
+}
+
+// ListContext is List with a context
+func (c *Conn) ListContext(ctx context.Context, keyword string, args ...string) ([]string, error) {
+	return c.list(ctx, keyword, args...)
+}
+
+func (c *Conn) list(ctx context.Context, keyword string, args ...string) ([]string, error) {
+	command := "LIST"
+	for _, arg := range append([]string{keyword}, args...) {
+		if len(arg) > 0 {
+			command += " " + arg
+		}
+	}
+	if _, _, err := c.cmd(ctx, StatusListFollows, "%s", command); err != nil {
+		return nil, err
+	}
+	return c.readLines(ctx)
+}
@@ -0,0 +1,88 @@
+package simplenntp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+var (
+	crlf = []byte("\r\n")
+	lf   = []byte("\n")
+)
+
+// A HeaderError is returned by Post for an article with headers that would
+// be corrupted on the way to the server. Line counts from 1.
+type HeaderError struct {
+	Line   int
+	Reason string
+}
+
+func (e HeaderError) Error() string {
+	return fmt.Sprintf("bad article header on line %d: %s", e.Line, e.Reason)
+}
+
+// prepareArticle checks the headers of p and returns it the way it has to be
+// sent after POST (RFC 3977 section 3.1.1): dot-stuffed, with CRLF line
+// endings and ending in CRLF. p itself is returned if it needs no changes,
+// which is the fast path for yEnc articles as the encoder already escapes
+// dots at the start of a line.
+func prepareArticle(p []byte) ([]byte, error) {
+	if err := checkHeader(p); err != nil {
+		return nil, err
+	}
+	if !needsStuffing(p) {
+		return p, nil
+	}
+	return dotStuff(p), nil
+}
+
+// checkHeader rejects NUL bytes and LFs without a CR before them in the
+// headers of p, everything up to the first empty line.
+func checkHeader(p []byte) error {
+	header := p
+	if i := bytes.Index(p, []byte("\r\n\r\n")); i >= 0 {
+		header = p[:i+2]
+	}
+	line := 1
+	for i, b := range header {
+		switch b {
+		case 0:
+			return HeaderError{line, "contains a NUL byte"}
+		case '\n':
+			if i == 0 || header[i-1] != '\r' {
+				return HeaderError{line, "contains a bare LF"}
+			}
+			line++
+		}
+	}
+	return nil
+}
+
+// needsStuffing reports whether p has lines starting with a dot, bare LFs,
+// or doesn't end in CRLF.
+func needsStuffing(p []byte) bool {
+	return len(p) == 0 || p[0] == '.' || !bytes.HasSuffix(p, crlf) ||
+		bytes.Contains(p, []byte("\n.")) ||
+		bytes.Count(p, lf) != bytes.Count(p, crlf)
+}
+
+// dotStuff doubles dots at the start of a line, turns bare LFs into CRLF and
+// makes sure p ends in CRLF.
+func dotStuff(p []byte) []byte {
+	out := make([]byte, 0, len(p)+len(p)/64+2)
+	bol := true
+	for i, b := range p {
+		if bol && b == '.' {
+			out = append(out, '.')
+		}
+		if b == '\n' && (i == 0 || p[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+		bol = b == '\n'
+	}
+	if !bytes.HasSuffix(out, crlf) {
+		out = append(out, crlf...)
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package simplenntp
+
+import "testing"
+
+func TestPrepareArticle(t *testing.T) {
+	tests := []struct {
+		in, out string
+		err     bool
+	}{
+		// yEnc style articles go through untouched
+		{"Subject: x\r\n\r\n=ybegin\r\nabc\r\n=yend\r\n", "Subject: x\r\n\r\n=ybegin\r\nabc\r\n=yend\r\n", false},
+		{"Subject: x\r\n\r\n.\r\n..two\r\nend\r\n", "Subject: x\r\n\r\n..\r\n...two\r\nend\r\n", false},
+		{".Odd: header\r\n\r\nbody\r\n", "..Odd: header\r\n\r\nbody\r\n", false},
+		{"Subject: x\r\n\r\nno newline", "Subject: x\r\n\r\nno newline\r\n", false},
+		{"Subject: x\r\n\r\nbare\n.lf\n", "Subject: x\r\n\r\nbare\r\n..lf\r\n", false},
+		{"Subject: x\r\n\r\nnul \x00 in body\r\n", "Subject: x\r\n\r\nnul \x00 in body\r\n", false},
+		{"", "\r\n", false},
+		{"Subject: x\nFrom: y\r\n\r\nbody\r\n", "", true},
+		{"Subject: x\r\n\nbody\r\n", "", true},
+		{"Subject: \x00\r\n\r\nbody\r\n", "", true},
+	}
+	for _, test := range tests {
+		out, err := prepareArticle([]byte(test.in))
+		if test.err {
+			if _, ok := err.(HeaderError); !ok {
+				t.Errorf("%q: expected a HeaderError, got %v", test.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.in, err)
+		} else if string(out) != test.out {
+			t.Errorf("%q: got %q, want %q", test.in, out, test.out)
+		}
+	}
+
+	// No copy is made when nothing needs to change
+	p := []byte("Subject: x\r\n\r\nbody\r\n")
+	if out, _ := prepareArticle(p); &out[0] != &p[0] {
+		t.Errorf("article was copied")
+	}
+}
+
+func TestPostDotStuffing(t *testing.T) {
+	conn, articles := fakePostServer(t, map[string]string{"POST": "340 send it\r\n"})
+	if err := conn.Post([]byte("Subject: x\r\n\r\n.\r\nbody"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-articles; got != "Subject: x\r\n\r\n..\r\nbody\r\n" {
+		t.Errorf("server got %q", got)
+	}
+
+	// Bad headers are refused before anything is sent
+	if err := conn.Post([]byte("Subject: x\nbody\n"), 4); err == nil {
+		t.Errorf("expected a bare LF in the headers to be refused")
+	}
+	if err := conn.Post([]byte("Subject: y\r\n\r\nbody\r\n"), 4); err != nil {
+		t.Errorf("connection out of step after a refused article: %s", err)
+	}
+}
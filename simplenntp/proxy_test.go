@@ -0,0 +1,225 @@
+package simplenntp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// proxyStandIn runs a minimal SOCKS5 or HTTP CONNECT proxy that checks the
+// credentials and counts the connections it relayed. For SOCKS5 it also
+// records the address type of the last request.
+type proxyStandIn struct {
+	user, pass string
+	relayed    int32
+	addrType   int32
+	addr       string
+	delay      time.Duration
+}
+
+func startProxy(t *testing.T, kind, user, pass string) *proxyStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxyStandIn{user: user, pass: pass, addr: l.Addr().String()}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(kind, "socks5") {
+				go p.socks5(c)
+			} else {
+				go p.http(c)
+			}
+		}
+	}()
+	return p
+}
+
+func (p *proxyStandIn) relay(c net.Conn, r io.Reader, target string) {
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		c.Close()
+		return
+	}
+	atomic.AddInt32(&p.relayed, 1)
+	go func() {
+		io.Copy(upstream, r)
+		upstream.Close()
+	}()
+	io.Copy(c, upstream)
+	c.Close()
+}
+
+func (p *proxyStandIn) socks5(c net.Conn) {
+	time.Sleep(p.delay)
+	r := bufio.NewReader(c)
+	head := make([]byte, 2)
+	io.ReadFull(r, head)
+	io.ReadFull(r, make([]byte, head[1]))
+	if len(p.user) > 0 {
+		c.Write([]byte{0x05, 0x02})
+		ver, _ := r.ReadByte()
+		ulen, _ := r.ReadByte()
+		user := make([]byte, ulen)
+		io.ReadFull(r, user)
+		plen, _ := r.ReadByte()
+		pass := make([]byte, plen)
+		io.ReadFull(r, pass)
+		if ver != 0x01 || string(user) != p.user || string(pass) != p.pass {
+			c.Write([]byte{0x01, 0x01})
+			c.Close()
+			return
+		}
+		c.Write([]byte{0x01, 0x00})
+	} else {
+		c.Write([]byte{0x05, 0x00})
+	}
+
+	req := make([]byte, 4)
+	io.ReadFull(r, req)
+	atomic.StoreInt32(&p.addrType, int32(req[3]))
+	var host string
+	switch req[3] {
+	case 0x01, 0x04:
+		ip := make([]byte, net.IPv4len)
+		if req[3] == 0x04 {
+			ip = make([]byte, net.IPv6len)
+		}
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 0x03:
+		l, _ := r.ReadByte()
+		name := make([]byte, l)
+		io.ReadFull(r, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(r, port)
+	c.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+	p.relay(c, r, net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port))))
+}
+
+func (p *proxyStandIn) http(c net.Conn) {
+	r := bufio.NewReader(c)
+	req, err := http.ReadRequest(r)
+	if err != nil || req.Method != "CONNECT" {
+		c.Close()
+		return
+	}
+	if len(p.user) > 0 {
+		user, pass, ok := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth()
+		if !ok || user != p.user || pass != p.pass {
+			c.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			c.Close()
+			return
+		}
+	}
+	c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	p.relay(c, r, req.Host)
+}
+
+func TestProxyDialer(t *testing.T) {
+	cert, _ := testCert(t)
+	replies := map[string]string{
+		"greeting":         "200 behind the proxy\r\n",
+		"TLS CAPABILITIES": "101 caps\r\nVERSION 2\r\nPOST\r\n.\r\n",
+		"CAPABILITIES":     "101 caps\r\nVERSION 2\r\nSTARTTLS\r\n.\r\n",
+		"QUIT":             "205 bye\r\n",
+	}
+	port := listenFake(t, replies, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	for _, kind := range []string{"socks5", "http"} {
+		for _, auth := range []string{"", "user:secret@"} {
+			p := startProxy(t, kind, "user", "secret")
+			if len(auth) == 0 {
+				p.user = ""
+			}
+			dialer, err := ProxyDialer(kind+"://"+auth+p.addr, &net.Dialer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, mode := range []TLSMode{NoTLS, StartTLS} {
+				conn, err := Dial(Options{Address: "127.0.0.1", Port: port, TLS: mode, TLSOptions: &TLSOptions{InsecureSkipVerify: true}, Dialer: dialer})
+				if err != nil {
+					t.Errorf("%s %q: %s", kind, auth, err)
+					continue
+				}
+				if conn.Greeting != "behind the proxy" {
+					t.Errorf("%s: unexpected greeting %q", kind, conn.Greeting)
+				}
+				if conn.TLS != (mode == StartTLS) {
+					t.Errorf("%s: expected TLS to be %v", kind, mode == StartTLS)
+				}
+				conn.Quit()
+			}
+			if atomic.LoadInt32(&p.relayed) != 2 {
+				t.Errorf("%s: expected 2 relayed connections, got %d", kind, p.relayed)
+			}
+		}
+
+		// Wrong password
+		p := startProxy(t, kind, "user", "secret")
+		dialer, _ := ProxyDialer(kind+"://user:wrong@"+p.addr, &net.Dialer{})
+		if _, err := Dial(Options{Address: "127.0.0.1", Port: port, Dialer: dialer}); err == nil || !strings.Contains(err.Error(), "proxy") {
+			t.Errorf("%s: expected a proxy error, got %v", kind, err)
+		}
+	}
+
+	// socks5 resolves the name locally, socks5h sends it to the proxy
+	for kind, addrType := range map[string]int32{"socks5": 0x01, "socks5h": 0x03} {
+		p := startProxy(t, kind, "", "")
+		dialer, err := ProxyDialer(kind+"://"+p.addr, &net.Dialer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := Dial(Options{Address: "localhost", Port: port, Dialer: dialer})
+		if err != nil {
+			t.Errorf("%s: %s", kind, err)
+			continue
+		}
+		conn.Quit()
+		if got := atomic.LoadInt32(&p.addrType); got != addrType {
+			t.Errorf("%s: expected address type %d, got %d", kind, addrType, got)
+		}
+	}
+
+	// The handshake gets the configured connect timeout, not the default
+	defer func(d time.Duration) { DefaultTimeouts.Connect = d }(DefaultTimeouts.Connect)
+	DefaultTimeouts.Connect = 50 * time.Millisecond
+	for _, connect := range []time.Duration{time.Second, -1} {
+		p := startProxy(t, "socks5", "", "")
+		p.delay = 200 * time.Millisecond
+		dialer, _ := ProxyDialer("socks5://"+p.addr, &net.Dialer{})
+		conn, err := Dial(Options{Address: "127.0.0.1", Port: port, Dialer: dialer, Timeouts: Timeouts{Connect: connect}})
+		if err != nil {
+			t.Errorf("connect timeout %s: %s", connect, err)
+			continue
+		}
+		conn.Quit()
+	}
+	p := startProxy(t, "socks5", "", "")
+	p.delay = time.Second
+	dialer, _ := ProxyDialer("socks5://"+p.addr, &net.Dialer{})
+	if _, err := Dial(Options{Address: "127.0.0.1", Port: port, Dialer: dialer, Timeouts: Timeouts{Connect: 100 * time.Millisecond}}); err == nil {
+		t.Error("expected the proxy handshake to time out")
+	}
+
+	for _, bad := range []string{"ftp://host:21", "socks5://", "%%"} {
+		if _, err := ProxyDialer(bad, &net.Dialer{}); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package simplenntp
+
+import (
+	"context"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The reading side of NNTP (RFC 3977 section 6 and 7, RFC 2980). Articles
+// are named by message-id with angle brackets, or by number in the
+// currently selected group.
+
+// ModeReader switches a mode-switching server to reader mode. The greeting
+// may change, so PostingAllowed is updated and Caps cleared.
+func (c *Conn) ModeReader() error {
+	return c.ModeReaderContext(context.Background())
+}
+
+// ModeReaderContext is ModeReader with a context
+func (c *Conn) ModeReaderContext(ctx context.Context) error {
+	code, _, err := c.cmd(ctx, 20, "MODE READER")
+	if err != nil {
+		return err
+	}
+	c.PostingAllowed = code == StatusPostingAllowed
+	c.Caps = nil
+	return nil
+}
+
+// Group selects a group and returns its estimated article count and the
+// lowest and highest article numbers. Status isn't set.
+func (c *Conn) Group(name string) (*Group, error) {
+	return c.GroupContext(context.Background(), name)
+}
+
+// GroupContext is Group with a context
+func (c *Conn) GroupContext(ctx context.Context, name string) (*Group, error) {
+	_, line, err := c.cmd(ctx, StatusGroupSelected, "GROUP %s", name)
+	if err != nil {
+		return nil, err
+	}
+	// 211 count low high group
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, ProtocolError("bad GROUP response: " + line)
+	}
+	g := &Group{Name: fields[3]}
+	var err1, err2, err3 error
+	g.Count, err1 = strconv.ParseInt(fields[0], 10, 64)
+	g.Low, err2 = strconv.ParseInt(fields[1], 10, 64)
+	g.High, err3 = strconv.ParseInt(fields[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, ProtocolError("bad GROUP response: " + line)
+	}
+	return g, nil
+}
+
+// Stat checks if an article exists and returns its number and message-id.
+// The number is 0 when asking by message-id.
+func (c *Conn) Stat(id string) (int64, string, error) {
+	return c.StatContext(context.Background(), id)
+}
+
+// StatContext is Stat with a context
+func (c *Conn) StatContext(ctx context.Context, id string) (int64, string, error) {
+	_, line, err := c.cmd(ctx, StatusArticleExists, "STAT %s", id)
+	if err != nil {
+		return 0, "", err
+	}
+	return parseArticleResponse(line)
+}
+
+// An Article is the response to ARTICLE. Body has to be read before the next
+// command is sent, whatever is left of it is skipped then.
+type Article struct {
+	Number    int64
+	MessageID string
+	Header    textproto.MIMEHeader
+	Body      io.Reader
+}
+
+// Article fetches an article
+func (c *Conn) Article(id string) (*Article, error) {
+	return c.ArticleContext(context.Background(), id)
+}
+
+// ArticleContext is Article with a context. Its deadline and cancellation
+// also apply to reading the body.
+func (c *Conn) ArticleContext(ctx context.Context, id string) (*Article, error) {
+	_, line, err := c.cmd(ctx, StatusArticleFollows, "ARTICLE %s", id)
+	if err != nil {
+		return nil, err
+	}
+	d := c.newDotReader(ctx)
+	a := &Article{Body: d}
+	if a.Number, a.MessageID, err = parseArticleResponse(line); err != nil {
+		return nil, err
+	}
+	if a.Header, err = readHeader(d); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Head fetches the headers of an article
+func (c *Conn) Head(id string) (textproto.MIMEHeader, error) {
+	return c.HeadContext(context.Background(), id)
+}
+
+// HeadContext is Head with a context
+func (c *Conn) HeadContext(ctx context.Context, id string) (textproto.MIMEHeader, error) {
+	if _, _, err := c.cmd(ctx, StatusHeadFollows, "HEAD %s", id); err != nil {
+		return nil, err
+	}
+	return readHeader(c.newDotReader(ctx))
+}
+
+// Body fetches the body of an article. The reader returns io.EOF at the end
+// of the body and is only valid until the next command.
+func (c *Conn) Body(id string) (io.Reader, error) {
+	return c.BodyContext(context.Background(), id)
+}
+
+// BodyContext is Body with a context. Its deadline and cancellation also
+// apply to reading the body.
+func (c *Conn) BodyContext(ctx context.Context, id string) (io.Reader, error) {
+	if _, _, err := c.cmd(ctx, StatusBodyFollows, "BODY %s", id); err != nil {
+		return nil, err
+	}
+	return c.newDotReader(ctx), nil
+}
+
+// readHeader reads header lines up to the empty line or the end of the data
+// block, unfolding continuation lines.
+func readHeader(d *dotReader) (textproto.MIMEHeader, error) {
+	h := make(textproto.MIMEHeader)
+	var key string
+	for {
+		line, err := d.readLine()
+		if err == io.EOF {
+			return h, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			return h, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(key) > 0 {
+				values := h[key]
+				values[len(values)-1] += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, ProtocolError("bad header line: " + line)
+		}
+		key = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(line[:i]))
+		h.Add(key, strings.TrimSpace(line[i+1:]))
+	}
+}
+
+// parseArticleResponse parses "n message-id" from a 220-223 response
+func parseArticleResponse(line string) (int64, string, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return 0, "", ProtocolError("bad article response: " + line)
+	}
+	n, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", ProtocolError("bad article response: " + line)
+	}
+	return n, fields[1], nil
+}
+
+// An Overview is a line of an OVER or XOVER response
+type Overview struct {
+	Number     int64
+	Subject    string
+	From       string
+	Date       string
+	MessageID  string
+	References string
+	Bytes      int64
+	Lines      int64
+	// Any further fields, as listed by LIST OVERVIEW.FMT
+	Extra []string
+}
+
+// Over returns the overview of the articles in rng of the selected group,
+// e.g. "1000-2000" or "1000-". It falls back to XOVER for servers that
+// don't know OVER.
+func (c *Conn) Over(rng string) ([]Overview, error) {
+	return c.OverContext(context.Background(), rng)
+}
+
+// OverContext is Over with a context
+func (c *Conn) OverContext(ctx context.Context, rng string) ([]Overview, error) {
+	command := "OVER"
+	if c.Caps != nil && !c.Caps.Has("OVER") {
+		command = "XOVER"
+	}
+	_, _, err := c.cmd(ctx, StatusOverviewFollows, "%s %s", command, rng)
+	if e, ok := err.(Error); ok && command == "OVER" && e.Code == StatusUnknownCommand {
+		_, _, err = c.cmd(ctx, StatusOverviewFollows, "XOVER %s", rng)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := c.readLines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	over := make([]Overview, 0, len(lines))
+	for _, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 8 {
+			return nil, ProtocolError("bad overview line: " + line)
+		}
+		o := Overview{
+			Subject:    fields[1],
+			From:       fields[2],
+			Date:       fields[3],
+			MessageID:  fields[4],
+			References: fields[5],
+			Extra:      fields[8:],
+		}
+		var err1 error
+		if o.Number, err1 = strconv.ParseInt(fields[0], 10, 64); err1 != nil {
+			return nil, ProtocolError("bad overview line: " + line)
+		}
+		// Some servers leave bytes and lines empty
+		o.Bytes, _ = strconv.ParseInt(fields[6], 10, 64)
+		o.Lines, _ = strconv.ParseInt(fields[7], 10, 64)
+		over = append(over, o)
+	}
+	return over, nil
+}
+
+// Date returns the server's clock in UTC
+func (c *Conn) Date() (time.Time, error) {
+	return c.DateContext(context.Background())
+}
+
+// DateContext is Date with a context
+func (c *Conn) DateContext(ctx context.Context) (time.Time, error) {
+	_, line, err := c.cmd(ctx, StatusDate, "DATE")
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse("20060102150405", strings.TrimSpace(line))
+	if err != nil {
+		return time.Time{}, ProtocolError("bad DATE response: " + line)
+	}
+	return t, nil
+}
@@ -0,0 +1,193 @@
+package simplenntp
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+const (
+	testHeader = "From: poster <poster@example.com>\r\n" +
+		"Subject: a long\r\n" +
+		"  subject\r\n" +
+		"Message-ID: <1@example.com>\r\n"
+	testBody = "..leading dot\r\n" +
+		"body\r\n" +
+		".\r\n"
+)
+
+func TestModeReader(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		"greeting":    "201 no posting\r\n",
+		"MODE READER": "200 posting allowed\r\n",
+	})
+	conn.Caps = &Capabilities{}
+	if err := conn.ModeReader(); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.PostingAllowed || conn.Caps != nil {
+		t.Errorf("got PostingAllowed %v and caps %v after MODE READER", conn.PostingAllowed, conn.Caps)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		"GROUP alt.test":     "211 1234 3000 4233 alt.test\r\n",
+		"GROUP alt.notthere": "411 no such group\r\n",
+	})
+	g, err := conn.Group("alt.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *g != (Group{Name: "alt.test", Count: 1234, Low: 3000, High: 4233}) {
+		t.Errorf("got %+v", g)
+	}
+	if _, err := conn.Group("alt.notthere"); err == nil {
+		t.Errorf("expected an error for a missing group")
+	} else if e, ok := err.(Error); !ok || e.Code != StatusNoSuchGroup || !e.Code.Temporary() {
+		t.Errorf("expected a 411 error, got %v", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		"STAT <1@example.com>": "223 0 <1@example.com>\r\n",
+		"STAT 3000":            "223 3000 <2@example.com>\r\n",
+	})
+	n, id, err := conn.Stat("<1@example.com>")
+	if err != nil || n != 0 || id != "<1@example.com>" {
+		t.Errorf("got %d %s %v", n, id, err)
+	}
+	n, id, err = conn.Stat("3000")
+	if err != nil || n != 3000 || id != "<2@example.com>" {
+		t.Errorf("got %d %s %v", n, id, err)
+	}
+	if _, _, err := conn.Stat("<missing@example.com>"); err == nil {
+		t.Errorf("expected an error for a missing article")
+	}
+}
+
+func TestArticle(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		"ARTICLE <1@example.com>": "220 0 <1@example.com>\r\n" + testHeader + "\r\n" + testBody,
+		"HEAD <1@example.com>":    "221 0 <1@example.com>\r\n" + testHeader + ".\r\n",
+		"BODY <1@example.com>":    "222 0 <1@example.com>\r\n" + testBody,
+		"DATE":                    "111 20261019123456\r\n",
+	})
+
+	a, err := conn.Article("<1@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.MessageID != "<1@example.com>" {
+		t.Errorf("got message-id %s", a.MessageID)
+	}
+	if got := a.Header.Get("Subject"); got != "a long subject" {
+		t.Errorf("folded subject came out as %q", got)
+	}
+	if got := a.Header.Get("Message-Id"); got != "<1@example.com>" {
+		t.Errorf("got Message-ID header %q", got)
+	}
+	body, err := ioutil.ReadAll(a.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != ".leading dot\r\nbody\r\n" {
+		t.Errorf("got body %q", body)
+	}
+
+	h, err := conn.Head("<1@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Get("From") != "poster <poster@example.com>" || len(h) != 3 {
+		t.Errorf("got header %v", h)
+	}
+
+	// The unread rest of the body is skipped before the next command
+	r, err := conn.Body("<1@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if _, err := r.Read(buf); err != nil || string(buf) != ".le" {
+		t.Errorf("got %q %v", buf, err)
+	}
+	date, err := conn.Date()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !date.Equal(time.Date(2026, 10, 19, 12, 34, 56, 0, time.UTC)) {
+		t.Errorf("got date %s", date)
+	}
+}
+
+func TestBodyCancelled(t *testing.T) {
+	// Never ends the body
+	conn := fakeServer(t, map[string]string{"BODY <1@example.com>": "222 0 <1@example.com>\r\nfirst line\r\n"})
+	conn.Timeouts.Read = -1
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := conn.BodyContext(ctx, "<1@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if body, err := ioutil.ReadAll(r); err != context.Canceled || string(body) != "first line\r\n" {
+		t.Errorf("expected the first line and context.Canceled, got %q %v", body, err)
+	}
+}
+
+func TestOver(t *testing.T) {
+	over := "224 overview follows\r\n" +
+		"3000\tsubject\tposter@example.com\tMon, 19 Oct 2026 12:00:00 +0000\t<1@example.com>\t\t1000\t10\tXref: x\r\n" +
+		"3001\tother\tposter@example.com\tMon, 19 Oct 2026 12:00:00 +0000\t<2@example.com>\t<1@example.com>\t\t\r\n" +
+		".\r\n"
+	for _, command := range []string{"OVER", "XOVER"} {
+		conn := fakeServer(t, map[string]string{command + " 3000-": over})
+		o, err := conn.Over("3000-")
+		if err != nil {
+			t.Fatalf("%s: %s", command, err)
+		}
+		if len(o) != 2 {
+			t.Fatalf("%s: got %d lines", command, len(o))
+		}
+		if o[0].Number != 3000 || o[0].Subject != "subject" || o[0].Bytes != 1000 || o[0].Lines != 10 ||
+			len(o[0].Extra) != 1 || o[0].Extra[0] != "Xref: x" {
+			t.Errorf("%s: got %+v", command, o[0])
+		}
+		if o[1].References != "<1@example.com>" || o[1].Bytes != 0 {
+			t.Errorf("%s: got %+v", command, o[1])
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		"LIST OVERVIEW.FMT": "215 order of fields\r\nSubject:\r\nFrom:\r\n.\r\n",
+	})
+	lines, err := conn.List("OVERVIEW.FMT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != "Subject:" {
+		t.Errorf("got %q", lines)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	conn, articles := fakePostServer(t, map[string]string{"POST": "340 send it\r\n"})
+	var total, calls int
+	conn.progress = func(n int) {
+		total += n
+		calls++
+	}
+	article := []byte("Subject: test\r\n\r\nbody\r\n")
+	if err := conn.Post(article, 10); err != nil {
+		t.Fatal(err)
+	}
+	<-articles
+	if total != len(article) || calls != 3 {
+		t.Errorf("got %d bytes in %d calls", total, calls)
+	}
+}
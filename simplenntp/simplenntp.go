@@ -5,21 +5,52 @@ package simplenntp
 
 import (
 	"bufio"
+	"compress/flate"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
-	"io"
 	"net"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// Connection timeout in seconds
-var timeout = time.Duration(20) * time.Second
+// Timeouts limit how long talking to a server may take. Connect covers
+// everything up to a usable connection, Read and Write every single read and
+// write, and a connection that has been Idle for longer is assumed to be
+// dropped by the server. Zero values use DefaultTimeouts, negative values
+// disable the timeout.
+type Timeouts struct {
+	Connect time.Duration
+	Read    time.Duration
+	Write   time.Duration
+	Idle    time.Duration
+}
+
+// DefaultTimeouts are used for the Timeouts that aren't set
+var DefaultTimeouts = Timeouts{
+	Connect: 20 * time.Second,
+	Read:    60 * time.Second,
+	Write:   60 * time.Second,
+}
 
-type TimeData struct {
-	Milliseconds int64
-	Bytes int
+func (t Timeouts) withDefaults() Timeouts {
+	pick := func(d, def time.Duration) time.Duration {
+		if d == 0 {
+			return def
+		}
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+	return Timeouts{
+		Connect: pick(t.Connect, DefaultTimeouts.Connect),
+		Read:    pick(t.Read, DefaultTimeouts.Read),
+		Write:   pick(t.Write, DefaultTimeouts.Write),
+		Idle:    pick(t.Idle, DefaultTimeouts.Idle),
+	}
 }
 
 // A ProtocolError represents responses from an NNTP server
@@ -31,7 +62,7 @@ func (p ProtocolError) Error() string {
 
 // An Error represents an error response from an NNTP server.
 type Error struct {
-        Code uint
+        Code StatusCode
         Msg  string
 }
 func (e Error) Error() string {
@@ -40,46 +71,268 @@ func (e Error) Error() string {
 
 
 type Conn struct {
-	conn  io.WriteCloser
+	conn  net.Conn
 	r     *bufio.Reader
-	tdchan chan *TimeData
 	close bool
+
+	// dot is the unfinished data block of the last multi-line response
+	dot *dotReader
+
+	// progress is called with the number of bytes written after every
+	// chunk of an article
+	progress func(n int)
+
+	// Greeting is the text of the server's greeting. PostingAllowed is false
+	// if the server greeted with 201, which often changes after logging in.
+	Greeting       string
+	PostingAllowed bool
+
+	// Caps are the capabilities the server reported last, nil if they
+	// haven't been asked for or the server doesn't support CAPABILITIES.
+	Caps *Capabilities
+
+	// TLS is true if the connection is encrypted. Authenticate refuses to
+	// send credentials over an unencrypted connection unless
+	// AllowCleartextAuth is set.
+	TLS                bool
+	AllowCleartextAuth bool
+
+	Timeouts Timeouts
+	lastUsed time.Time
+
+	// Compressed is true once COMPRESS DEFLATE is on
+	Compressed bool
+	zw         *flate.Writer
 }
 
-func newConn(c net.Conn, tdchan chan *TimeData) (res *Conn, err error) {
+// ErrCleartextAuth is returned by Authenticate when it would send
+// credentials over an unencrypted connection.
+var ErrCleartextAuth = ProtocolError("refusing to send credentials over an unencrypted connection")
+
+func newConn(ctx context.Context, c net.Conn, opts Options) (res *Conn, err error) {
 	res = &Conn{
 		conn: c,
 		r:    bufio.NewReaderSize(c, 4096),
-		tdchan: tdchan,
+		progress: opts.Progress,
+		Timeouts: opts.Timeouts.withDefaults(),
 	}
+	defer res.watch(ctx)()
 
-	_, err = res.r.ReadString('\n')
+	code, line, err := res.readResponse(ctx)
 	if err != nil {
-		return
+		c.Close()
+		return nil, err
 	}
+	switch code {
+	case StatusPostingAllowed:
+		res.PostingAllowed = true
+	case StatusPostingProhibited:
+		res.PostingAllowed = false
+	default:
+		c.Close()
+		return nil, Error{code, line}
+	}
+	res.Greeting = line
 
 	return
 }
 
+// Options say where and how Dial connects
+type Options struct {
+	Address string
+	Port    int
+
+	// TLS says if and how the connection is encrypted, TLSOptions how the
+	// server is verified. TLSOptions may be nil.
+	TLS        TLSMode
+	TLSOptions *TLSOptions
+
+	// Dialer makes the network connection, e.g. a ProxyDialer or
+	// BindDialer. Connections are made directly if it is nil.
+	Dialer NetDialer
+
+	Timeouts Timeouts
+
+	// Progress, if set, is called by Post after every chunk it wrote with
+	// the number of bytes written.
+	Progress func(n int)
+}
+
 // Dial connects to an NNTP server
-func Dial(address string, port int, useTLS bool, insecureSSL bool, tdchan chan *TimeData) (*Conn, error) {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", address, port), timeout)
+func Dial(opts Options) (*Conn, error) {
+	return DialContext(context.Background(), opts)
+}
+
+// DialContext connects to an NNTP server. Connecting gives up when ctx is
+// done or opts.Timeouts.Connect has passed, the other timeouts apply to the
+// returned Conn.
+func DialContext(ctx context.Context, opts Options) (*Conn, error) {
+	timeouts := opts.Timeouts.withDefaults()
+	address := opts.Address
+	mode := opts.TLS
+
+	var tlsConfig *tls.Config
+	if mode != NoTLS {
+		var err error
+		if tlsConfig, err = opts.TLSOptions.Config(address); err != nil {
+			return nil, err
+		}
+	}
+
+	if timeouts.Connect > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeouts.Connect)
+		defer cancel()
+	}
+
+	dialer := opts.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(opts.Port)))
 	if err != nil {
 		return nil, err
 	}
 
-	if useTLS {
+	switch mode {
+	case ImplicitTLS:
 		// Create and handshake a TLS connection
-		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: insecureSSL})
-		err = tlsConn.Handshake()
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := handshake(ctx, tlsConn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		c, err := newConn(ctx, tlsConn, opts)
+		if err != nil {
+			return nil, err
+		}
+		c.TLS = true
+		return c, nil
+
+	case StartTLS, OptionalStartTLS:
+		c, err := newConn(ctx, conn, opts)
 		if err != nil {
 			return nil, err
 		}
+		if err := c.startTLS(ctx, tlsConfig, mode == OptionalStartTLS); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+		return c, nil
+
+	default:
+		c, err := newConn(ctx, conn, opts)
+		if err != nil {
+			return nil, err
+		}
+		c.AllowCleartextAuth = true
+		return c, nil
+	}
+}
+
+// ioDeadline returns the deadline for a read or write that may take up to
+// d, or ctx's deadline if that is sooner.
+func ioDeadline(ctx context.Context, d time.Duration) time.Time {
+	var t time.Time
+	if d > 0 {
+		t = time.Now().Add(d)
+	}
+	if dl, ok := ctx.Deadline(); ok && (t.IsZero() || dl.Before(t)) {
+		t = dl
+	}
+	return t
+}
+
+// ioError returns ctx's error instead of the timeout it caused
+func ioError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// The deadline may pass just before the context notices
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		if dl, ok := ctx.Deadline(); ok && !time.Now().Before(dl) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+// watchConn interrupts any reads and writes on conn once ctx is done. The
+// returned function stops watching and only returns once the watcher has
+// exited, clearing the deadline again if it was set, so conn is safe to use
+// afterwards.
+func watchConn(ctx context.Context, conn net.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	interrupted := false
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			interrupted = true
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+		if interrupted {
+			conn.SetDeadline(time.Time{})
+		}
+	}
+}
+
+func (c *Conn) watch(ctx context.Context) func() {
+	return watchConn(ctx, c.conn)
+}
 
-		return newConn(tlsConn, tdchan)
+// write writes p within the write timeout
+func (c *Conn) write(ctx context.Context, p []byte) (int, error) {
+	c.conn.SetWriteDeadline(ioDeadline(ctx, c.Timeouts.Write))
+	var n int
+	var err error
+	if c.zw != nil {
+		// Flush straight away, the server is waiting for it
+		if n, err = c.zw.Write(p); err == nil {
+			err = c.zw.Flush()
+		}
 	} else {
-		return newConn(conn, tdchan)
+		n, err = c.conn.Write(p)
+	}
+	c.lastUsed = time.Now()
+	return n, ioError(ctx, err)
+}
+
+// readLine reads a line within the read timeout
+func (c *Conn) readLine(ctx context.Context) (string, error) {
+	c.conn.SetReadDeadline(ioDeadline(ctx, c.Timeouts.Read))
+	// A watcher may have interrupted the connection before the deadline
+	// above replaced its own
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
+	line, err := c.r.ReadString('\n')
+	c.lastUsed = time.Now()
+	return line, ioError(ctx, err)
+}
+
+// Idle returns how long the connection hasn't been used for
+func (c *Conn) Idle() time.Duration {
+	return time.Since(c.lastUsed)
+}
+
+// IdleExpired reports whether the connection has been idle for longer than
+// the Idle timeout, in which case the server has probably dropped it.
+func (c *Conn) IdleExpired() bool {
+	return c.Timeouts.Idle > 0 && c.Idle() > c.Timeouts.Idle
 }
 
 // cmd executes an NNTP command:
@@ -87,24 +340,43 @@ func Dial(address string, port int, useTLS bool, insecureSSL bool, tdchan chan *
 // reads the response line. If expectCode > 0, the status code on the
 // response line must match it. 1 digit expectCodes only check the first
 // digit of the status code, etc.
-func (c *Conn) cmd(expectCode uint, format string, args ...interface{}) (code uint, line string, err error) {
+func (c *Conn) cmd(ctx context.Context, expectCode StatusCode, format string, args ...interface{}) (code StatusCode, line string, err error) {
 	if c.close {
 		return 0, "", ProtocolError("connection closed")
 	}
-	// if c.br != nil {
-	// 	if err := c.br.discard(); err != nil {
-	// 		return 0, "", err
-	// 	}
-	// 	c.br = nil
-	// }
-	if _, err := fmt.Fprintf(c.conn, format+"\r\n", args...); err != nil {
+	defer c.watch(ctx)()
+	if c.dot != nil {
+		// Skip the rest of the last response nobody read
+		if err := c.dot.discard(); err != nil {
+			return 0, "", err
+		}
+	}
+	if _, err := c.write(ctx, []byte(fmt.Sprintf(format+"\r\n", args...))); err != nil {
+		return 0, "", err
+	}
+	code, line, err = c.readResponse(ctx)
+	if err != nil {
 		return 0, "", err
 	}
-	line, err = c.r.ReadString('\n')
+	if 1 <= expectCode && expectCode < 10 && code/100 != expectCode ||
+		10 <= expectCode && expectCode < 100 && code/10 != expectCode ||
+		100 <= expectCode && expectCode < 1000 && code != expectCode {
+		err = Error{code, line}
+	}
+	return
+}
+
+// readResponse reads a response line and splits it into code and text
+func (c *Conn) readResponse(ctx context.Context) (StatusCode, string, error) {
+	line, err := c.readLine(ctx)
 	if err != nil {
 		return 0, "", err
 	}
 	line = strings.TrimSpace(line)
+	if len(line) == 3 {
+		// Some servers leave out the text entirely
+		line += " "
+	}
 	if len(line) < 4 || line[3] != ' ' {
 		return 0, "", ProtocolError("short response: " + line)
 	}
@@ -112,46 +384,105 @@ func (c *Conn) cmd(expectCode uint, format string, args ...interface{}) (code ui
 	if err != nil {
 		return 0, "", ProtocolError("invalid response code: " + line)
 	}
-	code = uint(i)
-	line = line[4:]
-	if 1 <= expectCode && expectCode < 10 && code/100 != expectCode ||
-		10 <= expectCode && expectCode < 100 && code/10 != expectCode ||
-		100 <= expectCode && expectCode < 1000 && code != expectCode {
-		err = Error{code, line}
-	}
-	return
+	return StatusCode(i), line[4:], nil
 }
 
 // Authenticate logs in to the NNTP server.
 // It only sends the password if the server requires one.
 func (c *Conn) Authenticate(username, password string) error {
-	code, _, err := c.cmd(2, "AUTHINFO USER %s", username)
+	return c.AuthenticateContext(context.Background(), username, password)
+}
+
+// AuthenticateContext is Authenticate with a context
+func (c *Conn) AuthenticateContext(ctx context.Context, username, password string) error {
+	if !c.TLS && !c.AllowCleartextAuth {
+		return ErrCleartextAuth
+	}
+	code, _, err := c.cmd(ctx, 2, "AUTHINFO USER %s", username)
 	if code/100 == 3 {
-		_, _, err = c.cmd(2, "AUTHINFO PASS %s", password)
+		_, _, err = c.cmd(ctx, 2, "AUTHINFO PASS %s", password)
 	}
 	return err
 }
 
-// Post posts an article
+// AuthenticateSASL logs in with AUTHINFO SASL PLAIN (RFC 4643, RFC 4616)
+func (c *Conn) AuthenticateSASL(username, password string) error {
+	return c.AuthenticateSASLContext(context.Background(), username, password)
+}
+
+// AuthenticateSASLContext is AuthenticateSASL with a context
+func (c *Conn) AuthenticateSASLContext(ctx context.Context, username, password string) error {
+	if !c.TLS && !c.AllowCleartextAuth {
+		return ErrCleartextAuth
+	}
+	resp := base64.StdEncoding.EncodeToString([]byte("\x00" + username + "\x00" + password))
+	code, line, err := c.cmd(ctx, 2, "AUTHINFO SASL PLAIN %s", resp)
+	if err != nil {
+		return err
+	}
+	if code != StatusAuthAccepted && code != StatusSASLAccepted {
+		return Error{code, line}
+	}
+	return nil
+}
+
+// Compress turns on COMPRESS DEFLATE (RFC 8054) for everything sent and
+// received from now on. Call it after Authenticate, servers usually don't
+// allow it before.
+func (c *Conn) Compress() error {
+	return c.CompressContext(context.Background())
+}
+
+// CompressContext is Compress with a context
+func (c *Conn) CompressContext(ctx context.Context) error {
+	if c.Compressed {
+		return nil
+	}
+	if _, _, err := c.cmd(ctx, StatusCompressActive, "COMPRESS DEFLATE"); err != nil {
+		return err
+	}
+	zw, err := flate.NewWriter(c.conn, flate.BestSpeed)
+	if err != nil {
+		return err
+	}
+	c.zw = zw
+	// bufio.Reader is an io.ByteReader, so flate won't read past the
+	// compressed stream
+	c.r = bufio.NewReaderSize(flate.NewReader(c.r), 4096)
+	c.Compressed = true
+	return nil
+}
+
+// Post posts an article, writing it chunkSize bytes at a time. The article
+// is dot-stuffed and its line endings fixed up as needed, articles with a
+// NUL or bare LF in their headers are refused with a HeaderError.
 func (c *Conn) Post(p []byte, chunkSize int64) error {
-	if _, _, err := c.cmd(3, "POST"); err != nil {
+	return c.PostContext(context.Background(), p, chunkSize)
+}
+
+// PostContext is Post with a context
+func (c *Conn) PostContext(ctx context.Context, p []byte, chunkSize int64) error {
+	p, err := prepareArticle(p)
+	if err != nil {
 		return err
 	}
+	if _, _, err := c.cmd(ctx, 3, "POST"); err != nil {
+		return err
+	}
+	defer c.watch(ctx)()
 
 	plen := int64(len(p))
 	start := int64(0)
 	end := min(plen, chunkSize)
 
 	for {
-		n, err := c.conn.Write(p[start:end])
+		n, err := c.write(ctx, p[start:end])
 		if err != nil {
 			return err
 		}
 
-		// Write a data sent time point to our channel
-		c.tdchan <- &TimeData{
-			Milliseconds: time.Now().UnixNano() / 1e6,
-			Bytes: n,
+		if c.progress != nil {
+			c.progress(n)
 		}
 
 		// Calculate the next indexes
@@ -162,7 +493,7 @@ func (c *Conn) Post(p []byte, chunkSize int64) error {
 		}
 	}
 
-	if _, _, err := c.cmd(240, "."); err != nil {
+	if _, _, err := c.cmd(ctx, StatusArticlePosted, "."); err != nil {
 		return err
 	}
 	return nil
@@ -170,7 +501,13 @@ func (c *Conn) Post(p []byte, chunkSize int64) error {
 
 // Quit sends the QUIT command and closes the connection to the server.
 func (c *Conn) Quit() error {
-	_, _, err := c.cmd(0, "QUIT")
+	return c.QuitContext(context.Background())
+}
+
+// QuitContext is Quit with a context. The connection is closed even if ctx
+// is done before the server answers.
+func (c *Conn) QuitContext(ctx context.Context) error {
+	_, _, err := c.cmd(ctx, 0, "QUIT")
 	c.conn.Close()
 	c.close = true
 	return err
@@ -0,0 +1,674 @@
+package simplenntp
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the commands it receives with the canned responses in
+// replies, keyed by command. Unknown commands get a 500.
+func fakeServer(t *testing.T, replies map[string]string) *Conn {
+	conn, _ := fakePostServer(t, replies)
+	return conn
+}
+
+// fakePostServer is fakeServer that also accepts articles after a 340 reply
+// to POST and sends them to the returned channel.
+func fakePostServer(t *testing.T, replies map[string]string) (*Conn, chan string) {
+	client, server := net.Pipe()
+	articles := make(chan string, 10)
+	go serveFake(server, replies, nil, articles)
+
+	conn, err := newConn(context.Background(), client, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, articles
+}
+
+// listenFake runs a fake server on a local port and returns the port. If
+// tlsConfig is set STARTTLS upgrades the connection, after which replies
+// keyed "TLS <command>" take precedence.
+func listenFake(t *testing.T, replies map[string]string, tlsConfig *tls.Config) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFake(c, replies, tlsConfig, nil)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// serveFake answers commands on server. A 206 reply to COMPRESS DEFLATE
+// turns on compression.
+func serveFake(server net.Conn, replies map[string]string, tlsConfig *tls.Config, articles chan<- string) {
+	defer func() { server.Close() }()
+	r := bufio.NewReader(server)
+	var w io.Writer = server
+	flush := func() error { return nil }
+	greeting, ok := replies["greeting"]
+	if !ok {
+		greeting = "200 fake server ready\r\n"
+	}
+	server.Write([]byte(greeting))
+
+	secure := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		reply, ok := replies["TLS "+cmd]
+		if !ok || !secure {
+			reply, ok = replies[cmd]
+		}
+		if !ok {
+			reply = "500 unknown command\r\n"
+		}
+		if cmd == "STARTTLS" && tlsConfig != nil {
+			reply = "382 continue with TLS negotiation\r\n"
+		}
+		if _, err := w.Write([]byte(reply)); err != nil {
+			return
+		}
+		if err := flush(); err != nil {
+			return
+		}
+
+		switch {
+		case cmd == "QUIT":
+			return
+		case cmd == "STARTTLS" && tlsConfig != nil:
+			server = tls.Server(server, tlsConfig)
+			r = bufio.NewReader(server)
+			w = server
+			secure = true
+		case cmd == "COMPRESS DEFLATE" && strings.HasPrefix(reply, "206"):
+			r = bufio.NewReader(flate.NewReader(r))
+			fw, _ := flate.NewWriter(server, flate.BestSpeed)
+			w = fw
+			flush = fw.Flush
+		case cmd == "POST" && strings.HasPrefix(reply, "340") && articles != nil:
+			var article strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				article.WriteString(line)
+			}
+			articles <- article.String()
+			w.Write([]byte("240 article received\r\n"))
+			if err := flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// testCert returns a self-signed certificate for 127.0.0.1 and a pool
+// trusting it.
+func testCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake nntp"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+func TestListActive(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		"LIST ACTIVE alt.binaries.*": "215 list follows\r\n" +
+			"alt.binaries.test 0000012345 0000000001 y\r\n" +
+			"alt.binaries.mod 10 5 m\r\n" +
+			"alt.binaries.closed 10 5 n\r\n" +
+			".\r\n",
+		"LIST ACTIVE alt.nope": "215 list follows\r\n.\r\n",
+	})
+	defer conn.Quit()
+
+	groups, err := conn.ListActive("alt.binaries.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Group{
+		{Name: "alt.binaries.test", High: 12345, Low: 1, Status: "y"},
+		{Name: "alt.binaries.mod", High: 10, Low: 5, Status: "m"},
+		{Name: "alt.binaries.closed", High: 10, Low: 5, Status: "n"},
+	}
+	if len(groups) != len(expected) {
+		t.Fatalf("expected %d groups, got %d", len(expected), len(groups))
+	}
+	for i, g := range groups {
+		if g != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], g)
+		}
+		if g.Postable() != (g.Status != "n") {
+			t.Errorf("%s: wrong Postable()", g.Name)
+		}
+	}
+
+	groups, err = conn.ListActive("alt.nope")
+	if err != nil || len(groups) != 0 {
+		t.Errorf("expected no groups, got %v, %v", groups, err)
+	}
+
+	if _, err := conn.ListActive("unknown"); err == nil {
+		t.Errorf("expected an error")
+	} else if e, ok := err.(Error); !ok || e.Code != 500 {
+		t.Errorf("expected a 500 error, got %v", err)
+	}
+}
+
+func TestListNewsgroups(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		"LIST NEWSGROUPS": "215 descriptions follow\r\n" +
+			"alt.binaries.test\tTesting binaries\r\n" +
+			"alt.test Plain old testing\r\n" +
+			"..dotted\r\n" +
+			".\r\n",
+	})
+	defer conn.Quit()
+
+	descs, err := conn.ListNewsgroups("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"alt.binaries.test": "Testing binaries",
+		"alt.test":          "Plain old testing",
+		".dotted":           "",
+	}
+	if len(descs) != len(expected) {
+		t.Fatalf("expected %d groups, got %v", len(expected), descs)
+	}
+	for name, desc := range expected {
+		if descs[name] != desc {
+			t.Errorf("%s: expected %q, got %q", name, desc, descs[name])
+		}
+	}
+}
+
+func TestGreeting(t *testing.T) {
+	for greeting, posting := range map[string]bool{
+		"200 posting allowed\r\n":    true,
+		"201 no posting for you\r\n": false,
+	} {
+		client, server := net.Pipe()
+		go server.Write([]byte(greeting))
+		conn, err := newConn(context.Background(), client, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.PostingAllowed != posting {
+			t.Errorf("%q: expected PostingAllowed %v", greeting, posting)
+		}
+		server.Close()
+	}
+
+	client, server := net.Pipe()
+	go server.Write([]byte("502 go away\r\n"))
+	if _, err := newConn(context.Background(), client, Options{}); err == nil {
+		t.Errorf("expected an error for a 502 greeting")
+	} else if e, ok := err.(Error); !ok || e.Code != 502 {
+		t.Errorf("expected a 502 error, got %v", err)
+	}
+	server.Close()
+}
+
+func TestCapabilities(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		"CAPABILITIES": "101 Capability list:\r\n" +
+			"VERSION 2\r\n" +
+			"READER\r\n" +
+			"POST\r\n" +
+			"IHAVE\r\n" +
+			"STREAMING\r\n" +
+			"STARTTLS\r\n" +
+			"COMPRESS deflate\r\n" +
+			"AUTHINFO USER SASL\r\n" +
+			"SASL PLAIN\r\n" +
+			"LIST ACTIVE NEWSGROUPS\r\n" +
+			".\r\n",
+	})
+	defer conn.Quit()
+
+	caps, err := conn.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caps.Post || !caps.IHave || !caps.Streaming || !caps.StartTLS || !caps.Reader {
+		t.Errorf("missing capabilities: %+v", caps)
+	}
+	if strings.Join(caps.Version, " ") != "2" {
+		t.Errorf("expected version 2, got %v", caps.Version)
+	}
+	if strings.Join(caps.Compress, " ") != "DEFLATE" {
+		t.Errorf("expected DEFLATE, got %v", caps.Compress)
+	}
+	if strings.Join(caps.AuthInfo, " ") != "USER SASL" || strings.Join(caps.SASL, " ") != "PLAIN" {
+		t.Errorf("wrong AUTHINFO: %v %v", caps.AuthInfo, caps.SASL)
+	}
+	if !caps.Has("list") || caps.Has("MODE-READER") {
+		t.Errorf("wrong Has()")
+	}
+
+	caps = ParseCapabilities([]string{"VERSION 2", "READER"})
+	if caps.Post || caps.StartTLS || len(caps.AuthInfo) != 0 {
+		t.Errorf("unexpected capabilities: %+v", caps)
+	}
+}
+
+func TestCapabilitiesCancelled(t *testing.T) {
+	// Never ends the list
+	conn := fakeServer(t, map[string]string{"CAPABILITIES": "101 Capability list:\r\nVERSION 2\r\n"})
+	conn.Timeouts.Read = -1
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := conn.CapabilitiesContext(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	cert, _ := testCert(t)
+	replies := map[string]string{
+		"CAPABILITIES":       "101 caps\r\nVERSION 2\r\nSTARTTLS\r\n.\r\n",
+		"TLS CAPABILITIES":   "101 caps\r\nVERSION 2\r\nPOST\r\nAUTHINFO USER\r\n.\r\n",
+		"AUTHINFO USER test": "381 password please\r\n",
+		"AUTHINFO PASS pass": "281 welcome\r\n",
+		"QUIT":               "205 bye\r\n",
+	}
+	port := listenFake(t, replies, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	conn, err := Dial(Options{Address: "127.0.0.1", Port: port, TLS: StartTLS, TLSOptions: &TLSOptions{InsecureSkipVerify: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conn.TLS {
+		t.Errorf("expected an encrypted connection")
+	}
+	if conn.Caps == nil || !conn.Caps.Post || conn.Caps.StartTLS {
+		t.Errorf("expected the capabilities to be read again, got %+v", conn.Caps)
+	}
+	if err := conn.Authenticate("test", "pass"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	conn.Quit()
+}
+
+func TestStartTLSNotOffered(t *testing.T) {
+	port := listenFake(t, map[string]string{
+		"CAPABILITIES": "101 caps\r\nVERSION 2\r\nAUTHINFO USER\r\n.\r\n",
+		"QUIT":         "205 bye\r\n",
+	}, nil)
+
+	if _, err := Dial(Options{Address: "127.0.0.1", Port: port, TLS: StartTLS, TLSOptions: &TLSOptions{InsecureSkipVerify: true}}); err != ErrNoStartTLS {
+		t.Errorf("expected ErrNoStartTLS, got %v", err)
+	}
+
+	// Only with OptionalStartTLS the connection may stay unencrypted
+	conn, err := Dial(Options{Address: "127.0.0.1", Port: port, TLS: OptionalStartTLS, TLSOptions: &TLSOptions{InsecureSkipVerify: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.TLS {
+		t.Errorf("expected an unencrypted connection")
+	}
+	if err := conn.Authenticate("test", "pass"); err != ErrCleartextAuth {
+		t.Errorf("expected ErrCleartextAuth, got %v", err)
+	}
+	conn.Quit()
+
+	// Neither CAPABILITIES nor STARTTLS
+	old := listenFake(t, map[string]string{"QUIT": "205 bye\r\n"}, nil)
+	if _, err := Dial(Options{Address: "127.0.0.1", Port: old, TLS: StartTLS}); err != ErrNoStartTLS {
+		t.Errorf("expected ErrNoStartTLS without CAPABILITIES, got %v", err)
+	}
+	conn, err = Dial(Options{Address: "127.0.0.1", Port: old, TLS: OptionalStartTLS})
+	if err != nil || conn.TLS {
+		t.Errorf("expected an unencrypted connection without CAPABILITIES, got %v", err)
+	} else {
+		conn.Quit()
+	}
+
+	// Plain connections were asked for, so logging in is fine
+	conn, err = Dial(Options{Address: "127.0.0.1", Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conn.AllowCleartextAuth {
+		t.Errorf("expected AllowCleartextAuth for NoTLS")
+	}
+	conn.Quit()
+}
+
+// writePEM writes cert and its key to dir and returns their paths
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simplenntp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serverCert, _ := testCert(t)
+	clientCert, clientPool := testCert(t)
+	caFile, _ := writePEM(t, dir, "server", serverCert)
+	clientFile, clientKey := writePEM(t, dir, "client", clientCert)
+
+	replies := map[string]string{"QUIT": "205 bye\r\n"}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFake(tls.Server(c, &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    clientPool,
+			}), replies, nil, nil)
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	sum := sha256.Sum256(serverCert.Leaf.RawSubjectPublicKeyInfo)
+	goodPin := "sha256//" + base64.StdEncoding.EncodeToString(sum[:])
+	badPin := strings.Repeat("ab", sha256.Size)
+
+	tests := []struct {
+		name string
+		opts TLSOptions
+		ok   bool
+	}{
+		{"no ca", TLSOptions{ClientCert: clientFile, ClientKey: clientKey}, false},
+		{"ca", TLSOptions{CAFile: caFile, ClientCert: clientFile, ClientKey: clientKey}, true},
+		{"no client cert", TLSOptions{CAFile: caFile}, false},
+		{"wrong server name", TLSOptions{CAFile: caFile, ClientCert: clientFile, ClientKey: clientKey, ServerName: "news.example.com"}, false},
+		{"pin", TLSOptions{InsecureSkipVerify: true, ClientCert: clientFile, ClientKey: clientKey, PinSHA256: []string{badPin, goodPin}}, true},
+		{"wrong pin", TLSOptions{InsecureSkipVerify: true, ClientCert: clientFile, ClientKey: clientKey, PinSHA256: []string{badPin}}, false},
+	}
+	for _, test := range tests {
+		conn, err := Dial(Options{Address: "127.0.0.1", Port: port, TLS: ImplicitTLS, TLSOptions: &test.opts})
+		if (err == nil) != test.ok {
+			t.Errorf("%s: expected ok=%v, got %v", test.name, test.ok, err)
+		}
+		if err == nil {
+			if !conn.TLS {
+				t.Errorf("%s: expected an encrypted connection", test.name)
+			}
+			conn.Quit()
+		}
+	}
+
+	config, err := (&TLSOptions{CAFile: caFile, MinVersion: tls.VersionTLS12}).Config("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || config.ServerName != "127.0.0.1" {
+		t.Errorf("unexpected config: %+v", config)
+	}
+	if _, err := (&TLSOptions{PinSHA256: []string{"nope"}}).Config("x"); err == nil {
+		t.Errorf("expected an error for a bad pin")
+	}
+	if _, err := (&TLSOptions{CAFile: clientKey}).Config("x"); err == nil {
+		t.Errorf("expected an error for a CA file without certificates")
+	}
+
+	// A pinned certificate sent behind a leaf that isn't pinned
+	raw := [][]byte{clientCert.Certificate[0], serverCert.Certificate[0]}
+	for _, insecure := range []bool{true, false} {
+		config, err := (&TLSOptions{InsecureSkipVerify: insecure, PinSHA256: []string{goodPin}}).Config("x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		chains := [][]*x509.Certificate{{clientCert.Leaf}}
+		if insecure {
+			chains = nil
+		}
+		if err := config.VerifyPeerCertificate(raw, chains); err == nil {
+			t.Errorf("insecure=%v: expected an error for a pin behind the leaf", insecure)
+		}
+	}
+	config, err = (&TLSOptions{PinSHA256: []string{goodPin}}).Config("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.VerifyPeerCertificate(raw, [][]*x509.Certificate{{clientCert.Leaf, serverCert.Leaf}}); err != nil {
+		t.Errorf("expected a pin in the verified chain to match, got %v", err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	// Never answers POST
+	conn := fakeServer(t, map[string]string{"POST": ""})
+	conn.Timeouts.Read = 100 * time.Millisecond
+	start := time.Now()
+	err := conn.Post([]byte("hello\r\n.\r\n"), 1024)
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("read timeout took too long")
+	}
+	conn.Quit()
+
+	// Cancelled while waiting
+	conn = fakeServer(t, map[string]string{"POST": ""})
+	conn.Timeouts.Read = -1
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := conn.PostContext(ctx, []byte("hello\r\n.\r\n"), 1024); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	conn.Quit()
+
+	// No greeting
+	port := listenFake(t, map[string]string{"greeting": ""}, nil)
+	start = time.Now()
+	_, err = DialContext(context.Background(), Options{Address: "127.0.0.1", Port: port, Timeouts: Timeouts{Connect: 100 * time.Millisecond}})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("connect timeout took too long")
+	}
+}
+
+// TestWatchConn checks that a stopped watcher never touches the connection
+// again, and that a deadline it set is cleared.
+func TestWatchConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go io.Copy(server, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := watchConn(ctx, client)
+	cancel()
+	// Either the watcher already interrupted the connection or it never will
+	stop()
+	if _, err := client.Write([]byte("x")); err != nil {
+		t.Fatalf("connection still interrupted after stop: %v", err)
+	}
+	buf := make([]byte, 1)
+	if _, err := client.Read(buf); err != nil {
+		t.Fatalf("connection still interrupted after stop: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	stop = watchConn(ctx, client)
+	stop()
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := client.Write([]byte("x")); err != nil {
+		t.Fatalf("stopped watcher interrupted the connection: %v", err)
+	}
+}
+
+func TestIdleExpired(t *testing.T) {
+	conn := fakeServer(t, map[string]string{"QUIT": "205 bye\r\n"})
+	defer conn.Quit()
+
+	if conn.IdleExpired() {
+		t.Errorf("no idle timeout, should never expire")
+	}
+	conn.Timeouts.Idle = time.Minute
+	if conn.IdleExpired() {
+		t.Errorf("connection was just used")
+	}
+	conn.lastUsed = time.Now().Add(-2 * time.Minute)
+	if !conn.IdleExpired() {
+		t.Errorf("expected the connection to have expired")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	replies := map[string]string{
+		"COMPRESS DEFLATE": "206 compression active\r\n",
+		"CAPABILITIES":     "101 caps\r\nVERSION 2\r\nPOST\r\n.\r\n",
+		"POST":             "340 send it\r\n",
+		"QUIT":             "205 bye\r\n",
+	}
+	conn, articles := fakePostServer(t, replies)
+
+	if err := conn.Compress(); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.Compressed {
+		t.Errorf("expected Compressed to be set")
+	}
+	// A second call does nothing
+	if err := conn.Compress(); err != nil {
+		t.Fatal(err)
+	}
+
+	caps, err := conn.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caps.Post {
+		t.Errorf("capabilities garbled over compression: %+v", caps)
+	}
+
+	article := "Subject: squashed\r\n\r\n" + strings.Repeat("compress me please\r\n", 1000)
+	if err := conn.Post([]byte(article), 100); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-articles; got != article {
+		t.Errorf("article garbled over compression: got %d bytes, expected %d", len(got), len(article))
+	}
+	if err := conn.Quit(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// Not supported
+	conn = fakeServer(t, map[string]string{"COMPRESS DEFLATE": "503 no compression here\r\n"})
+	if err := conn.Compress(); err == nil {
+		t.Errorf("expected an error")
+	} else if e, ok := err.(Error); !ok || e.Code != 503 {
+		t.Errorf("expected a 503 error, got %v", err)
+	}
+	if conn.Compressed {
+		t.Errorf("Compressed should not be set")
+	}
+	conn.Quit()
+}
+
+func TestAuthenticateSASL(t *testing.T) {
+	conn := fakeServer(t, map[string]string{
+		// "\x00test\x00secret"
+		"AUTHINFO SASL PLAIN AHRlc3QAc2VjcmV0": "281 welcome\r\n",
+		"AUTHINFO SASL PLAIN AHRlc3QAd3Jvbmc=": "481 go away\r\n",
+	})
+	defer conn.Quit()
+	conn.AllowCleartextAuth = true
+
+	if err := conn.AuthenticateSASL("test", "wrong"); err == nil {
+		t.Errorf("expected an error")
+	} else if e, ok := err.(Error); !ok || e.Code != 481 {
+		t.Errorf("expected a 481 error, got %v", err)
+	}
+	if err := conn.AuthenticateSASL("test", "secret"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	conn.AllowCleartextAuth = false
+	if err := conn.AuthenticateSASL("test", "secret"); err != ErrCleartextAuth {
+		t.Errorf("expected ErrCleartextAuth, got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package simplenntp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// TLSMode says if and how Dial encrypts a connection
+type TLSMode int
+
+const (
+	// NoTLS leaves the connection unencrypted, credentials are sent in
+	// cleartext
+	NoTLS TLSMode = iota
+	// ImplicitTLS starts TLS straight after connecting, usually on port 563
+	ImplicitTLS
+	// StartTLS upgrades the connection with STARTTLS after the greeting
+	// (RFC 4642), usually on port 119. Dial fails with ErrNoStartTLS if the
+	// server doesn't offer STARTTLS.
+	StartTLS
+	// OptionalStartTLS is StartTLS, but leaves the connection unencrypted
+	// if the server doesn't offer STARTTLS
+	OptionalStartTLS
+)
+
+// ErrNoStartTLS is returned by Dial in StartTLS mode when the server doesn't
+// offer STARTTLS.
+var ErrNoStartTLS = ProtocolError("server doesn't offer STARTTLS")
+
+// TLSOptions configure how Dial sets up TLS
+type TLSOptions struct {
+	// Don't verify the server certificate at all. Pins are still checked.
+	InsecureSkipVerify bool
+	// Name to verify the certificate against and send with SNI, defaults to
+	// the address
+	ServerName string
+	// PEM file with the CA certificates to trust instead of the system ones
+	CAFile string
+	// PEM files with a client certificate and key for mutual TLS
+	ClientCert string
+	ClientKey  string
+	// Minimum TLS version, e.g. tls.VersionTLS12
+	MinVersion uint16
+	// SHA-256 hashes of acceptable server public keys (SubjectPublicKeyInfo),
+	// base64 or hex encoded, optionally prefixed with "sha256//". If set, the
+	// server must present a certificate with one of these keys.
+	PinSHA256 []string
+}
+
+// Config builds a tls.Config for connecting to address
+func (o *TLSOptions) Config(address string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: address}
+	if o == nil {
+		return config, nil
+	}
+
+	config.InsecureSkipVerify = o.InsecureSkipVerify
+	config.MinVersion = o.MinVersion
+	if len(o.ServerName) > 0 {
+		config.ServerName = o.ServerName
+	}
+
+	if len(o.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+	}
+
+	if len(o.ClientCert) > 0 || len(o.ClientKey) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.PinSHA256) > 0 {
+		pins := make(map[string]bool, len(o.PinSHA256))
+		for _, pin := range o.PinSHA256 {
+			sum, err := decodePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins[string(sum)] = true
+		}
+		pinned := func(cert *x509.Certificate) bool {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			return pins[string(sum[:])]
+		}
+		// rawCerts is whatever the server sent, only the leaf or certificates
+		// in a verified chain can be trusted to match a pin
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if config.InsecureSkipVerify {
+				if len(rawCerts) == 0 {
+					return fmt.Errorf("server sent no certificate")
+				}
+				cert, err := x509.ParseCertificate(rawCerts[0])
+				if err != nil {
+					return err
+				}
+				if pinned(cert) {
+					return nil
+				}
+			}
+			for _, chain := range verifiedChains {
+				for _, cert := range chain {
+					if pinned(cert) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("no certificate matches the pinned public keys")
+		}
+	}
+
+	return config, nil
+}
+
+// decodePin decodes a base64 or hex SHA-256 public key hash
+func decodePin(pin string) ([]byte, error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256//")
+	if sum, err := hex.DecodeString(pin); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	if sum, err := base64.StdEncoding.DecodeString(pin); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	return nil, fmt.Errorf("invalid SHA-256 pin '%s'", pin)
+}
+
+// handshake does the TLS handshake, giving up when ctx is done
+func handshake(ctx context.Context, conn *tls.Conn) error {
+	conn.SetDeadline(ioDeadline(ctx, 0))
+	stop := watchConn(ctx, conn)
+	err := conn.Handshake()
+	stop()
+	conn.SetDeadline(time.Time{})
+	return ioError(ctx, err)
+}
+
+// startTLS upgrades the connection if the server offers STARTTLS and reads
+// the capabilities again, as they may have changed. Unless optional is set
+// it's an error if the server doesn't offer STARTTLS.
+func (c *Conn) startTLS(ctx context.Context, config *tls.Config, optional bool) error {
+	var notOffered error = ErrNoStartTLS
+	if optional {
+		notOffered = nil
+	}
+
+	caps, err := c.CapabilitiesContext(ctx)
+	if err != nil {
+		if _, ok := err.(Error); !ok {
+			return err
+		}
+		// No CAPABILITIES, just try it
+	} else if !caps.StartTLS {
+		return notOffered
+	}
+
+	if _, _, err := c.cmd(ctx, StatusStartTLS, "STARTTLS"); err != nil {
+		if e, ok := err.(Error); ok && c.Caps == nil && (e.Code == StatusUnknownCommand || e.Code == StatusPermissionDenied) {
+			// Server without CAPABILITIES that doesn't know STARTTLS either
+			return notOffered
+		}
+		return err
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if err := handshake(ctx, tlsConn); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReaderSize(tlsConn, 4096)
+	c.TLS = true
+
+	c.Caps = nil
+	if _, err := c.CapabilitiesContext(ctx); err != nil {
+		if _, ok := err.(Error); !ok {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,37 +1,59 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/tomarus/gopoststuff/simplenntp"
+	"github.com/tomarus/GoPostStuff/simplenntp"
 )
 
 var slock sync.Mutex
 
 type FileData struct {
-	path string
-	size int64
+	path    string
+	name    string
+	offset  int64
+	size    int64
+	subject string
+	input   string
+	groups  string
+	// Names of the files an archive was made from
+	sources []string
 }
 
-type Totals struct {
-	start time.Time
-	end   time.Time
-	bytes int64
+// poster is what the connection goroutines post articles to, either a real
+// simplenntp.Conn or a DryConn.
+type poster interface {
+	Post(p []byte, chunkSize int64) error
+	Quit() error
 }
 
+// groupBySubject returns the distinct subjects in order of appearance and the
+// indexes of the files belonging to each of them.
+func groupBySubject(files []FileData) ([]string, map[string][]int) {
+	var subjects []string
+	bySubject := make(map[string][]int)
+	for i, fd := range files {
+		if _, ok := bySubject[fd.subject]; !ok {
+			subjects = append(subjects, fd.subject)
+		}
+		bySubject[fd.subject] = append(bySubject[fd.subject], i)
+	}
+	return subjects, bySubject
+}
+
+// articleSource generates the articles for one server into c and closes it
+type articleSource func(name string, c chan *Article)
+
 func Spawner(filenames []string) {
-	var wg sync.WaitGroup
 	files := make([]FileData, 0)
 
 	var altnzbpath string
-	slock.Lock()
-	nzbinfo := make(map[string]NzbFile, 0)
-	segs := make(map[string][]NzbSegment, 0)
-	slock.Unlock()
 
 	log.Debug("Spawner started")
 
@@ -39,15 +61,111 @@ func Spawner(filenames []string) {
 	for _, filename := range filenames {
 		err := filepath.Walk(filename, func(path string, fi os.FileInfo, err error) error {
 			if !fi.IsDir() && fi.Size() > 0 {
-				files = append(files, FileData{path: path, size: fi.Size()})
+				var subject string
+				if *dirSubjectFlag {
+					subject = filepath.Base(filepath.Dir(path))
+				} else {
+					subject = *subjectFlag
+				}
+				files = append(files, FileData{path: path, name: filepath.Base(path), size: fi.Size(), subject: subject, input: filename})
 			}
 			return err
 		})
 		if err != nil {
-			log.Fatalf("Spawner walk error: %s", err)
+			fatalf("Spawner walk error: %s", err)
+		}
+	}
+
+	// Work out which groups files can go to and check them before spending
+	// time on archives and checksums. Any rule may match once files are
+	// archived or split, so every group in the rules is checked.
+	rules, err := ParseGroupRules(Config.Routing.Rule)
+	if err != nil {
+		fatalf("Routing error: %s", err)
+	}
+	var groups string
+	if len(*groupFlag) > 0 {
+		groups = *groupFlag
+	} else {
+		groups = Config.Global.DefaultGroup
+	}
+	serverList := selectServers()
+	groupLists := []string{groups}
+	for _, rule := range rules {
+		groupLists = append(groupLists, rule.groups)
+	}
+	checkGroups(serverList, groupLists)
+	if err := CheckCrosspost(groupLists, serverList); err != nil {
+		fatalf("Crosspost error: %s", err)
+	}
+
+	// Scratch space for archives and checksum manifests, removed when done
+	tmpdir, err := ioutil.TempDir("", "gopoststuff")
+	if err != nil {
+		fatalf("TempDir error: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	// fatalf skips deferred calls and this may hold unencrypted copies
+	onFatal(func() { os.RemoveAll(tmpdir) })
+
+	// Maybe pack everything into encrypted archives first
+	var archive string
+	if len(*archiveFlag) > 0 {
+		archive = *archiveFlag
+	} else {
+		archive = Config.Global.Archive
+	}
+	var archivePass string
+	if len(archive) > 0 {
+		if len(*archivePassFlag) > 0 {
+			archivePass = *archivePassFlag
+		} else if len(Config.Global.ArchivePassword) > 0 {
+			archivePass = Config.Global.ArchivePassword
+		} else {
+			archivePass, err = GeneratePassword(16)
+			if err != nil {
+				fatalf("Archive error: %s", err)
+			}
+		}
+
+		files, err = CreateArchives(files, archive, archivePass, tmpdir)
+		if err != nil {
+			fatalf("Archive error: %s", err)
+		}
+	}
+
+	// Maybe split large files into volumes
+	var splitSize int64
+	if *splitFlag > 0 {
+		splitSize = *splitFlag
+	} else {
+		splitSize = Config.Global.SplitSize
+	}
+	files = SplitFiles(files, splitSize)
+
+	// Maybe generate checksum manifests to post along with the files
+	var checksums string
+	if len(*checksumsFlag) > 0 {
+		checksums = *checksumsFlag
+	} else {
+		checksums = Config.Global.Checksums
+	}
+	if len(checksums) > 0 {
+		types, err := ParseChecksums(checksums)
+		if err != nil {
+			fatalf("Checksums error: %s", err)
+		}
+
+		manifests, err := CreateChecksums(files, types, tmpdir)
+		if err != nil {
+			fatalf("Checksums error: %s", err)
 		}
+		files = append(files, manifests...)
 	}
 
+	// Work out which groups each file goes to
+	RouteGroups(files, rules, groups)
+
 	// Log a message about what we're posting
 	var totalBytes int64
 	for _, fd := range files {
@@ -56,8 +174,129 @@ func Spawner(filenames []string) {
 	totalMB := float64(totalBytes) / 1024 / 1024
 	log.Info("Found %d file(s) totalling %.1fMiB", len(files), totalMB)
 
-	// Make a channel to stuff TimeDatas into
-	tdchan := make(chan *simplenntp.TimeData, 100000)
+	if len(files) > 0 {
+		hooks.SetJob(files[0].subject, len(files), totalBytes)
+	}
+	hooks.Fire(&HookEvent{Event: HookStart, Status: "running"})
+
+	// Count how many entries share each file so volumes can share one mmap
+	refs := make(map[string]int)
+	for _, fd := range files {
+		refs[fd.path]++
+	}
+
+	progress := NewProgress()
+	for _, fd := range files {
+		progress.AddFile(fd.name, fd.size)
+	}
+
+	// Generate articles straight from the mmapped files
+	source := func(name string, c chan *Article) {
+		mc := NewMmapCache()
+		for filenum, fd := range files {
+			// Open and mmap the file
+			md, err := mc.MapFile(fd.path, refs[fd.path]*len(serverList))
+			if err != nil {
+				fatalf("MapFile error: %s", err)
+			}
+
+			// Work out how many parts we need
+			parts := fd.size / Config.Global.ArticleSize
+			rem := fd.size % Config.Global.ArticleSize
+			if rem > 0 {
+				parts++
+			}
+
+			// Build some articles
+			for partnum := int64(0); partnum < parts; partnum++ {
+				start := partnum * Config.Global.ArticleSize
+				end := min((partnum+1)*Config.Global.ArticleSize, fd.size)
+				ad := &ArticleData{
+					PartNum:   partnum + 1,
+					PartTotal: parts,
+					PartSize:  end - start,
+					PartBegin: start,
+					PartEnd:   end,
+					FileNum:   filenum + 1,
+					FileTotal: len(files),
+					FileSize:  fd.size,
+					FileName:  fd.name,
+					Groups:    fd.groups,
+				}
+				if len(altnzbpath) == 0 {
+					altnzbpath = SafeFileName(fd.subject)
+				}
+				a := NewArticle(md.data[fd.offset+start:fd.offset+end], ad, fd.subject)
+				c <- a
+			}
+
+			if md.Decrement() {
+				err = mc.CloseFile(fd.path)
+				if err != nil {
+					fatalf("CloseFile error: %s", err)
+				}
+				log.Debug("[%s] Closed file %s", name, fd.path)
+			}
+		}
+
+		close(c)
+	}
+
+	nzbinfo, segs := postArticles(serverList, source, progress)
+
+	// Add some metadata
+	// The archives we made are what needs unpacking, so their password wins
+	var meta []Meta
+	if len(archivePass) > 0 {
+		if len(*nzbMetaPass) > 0 && *nzbMetaPass != archivePass {
+			log.Warning("Ignoring -rarpw, the Nzb gets the password of the archives")
+		}
+		meta = setPassword(meta, archivePass)
+	} else if len(*nzbMetaPass) > 0 {
+		meta = setPassword(meta, *nzbMetaPass)
+	}
+
+	nzb := buildNzb(nzbinfo, segs, meta)
+	if len(*spoolFlag) > 0 {
+		var subject string
+		if len(files) > 0 {
+			subject = files[0].subject
+		}
+		err = WriteSpoolManifest(*spoolFlag, nzb, subject)
+		if err != nil {
+			fatalf("Spool error: %s", err)
+		}
+	}
+	finishJob(nzb, altnzbpath)
+}
+
+// finishJob writes and uploads the Nzb, then lets the hooks know we're done
+func finishJob(nzb *Nzb, altnzbpath string) {
+	nzbpath, err := writeNzb(nzb, altnzbpath)
+	if err != nil {
+		fatalf("Error while creating Nzb: %s", err)
+	}
+	// Nothing was posted, so the Nzb points at articles that don't exist
+	if offline() {
+		log.Info("Not uploading the Nzb of an offline run")
+		hooks.Fire(&HookEvent{Event: HookSuccess, Status: "offline", Nzb: nzbpath})
+		return
+	}
+	NewNzbUploader(Config.Upload).Upload(nzbpath)
+	hooks.Fire(&HookEvent{Event: HookSuccess, Status: "ok", Nzb: nzbpath})
+}
+
+// selectServers returns the servers to post to
+func selectServers() map[string]*ConfigServer {
+	if offline() {
+		if len(*spoolFlag) > 0 {
+			log.Info("Writing articles to spool %s, nothing will be posted", *spoolFlag)
+		} else {
+			log.Info("Dry run, nothing will be posted")
+		}
+		// Every real server would only write the same articles again
+		return map[string]*ConfigServer{"offline": {Connections: 1}}
+	}
 
 	// Use specified server
 	serverList := make(map[string]*ConfigServer, len(Config.Server))
@@ -68,77 +307,74 @@ func Spawner(filenames []string) {
 	} else {
 		serverList = Config.Server
 	}
+	return serverList
+}
+
+// offline reports whether articles should be generated without posting them
+func offline() bool {
+	return *dryRunFlag || len(*spoolFlag) > 0
+}
+
+// postArticles posts the articles from source to every server and returns
+// the Nzb information for everything that was posted.
+func postArticles(serverList map[string]*ConfigServer, source articleSource, progress *Progress) (map[string]NzbFile, map[string][]NzbSegment) {
+	var wg sync.WaitGroup
+
+	renderer, err := NewProgressRenderer(*progressFlag, time.Duration(*progressIntervalFlag)*time.Second, *progressFdFlag)
+	if err != nil {
+		fatalf("Progress error: %s", err)
+	}
+	for name := range serverList {
+		progress.AddServer(name)
+	}
+	progress.Start()
+
+	report := NewStatsReport()
+	// Show and maybe save the final statistics, also when giving up early
+	var reportOnce sync.Once
+	saveReport := func() {
+		reportOnce.Do(func() {
+			report.Finish()
+			report.Log()
+			statsFile := *statsFlag
+			if len(statsFile) == 0 {
+				statsFile = Config.Global.StatsFile
+			}
+			if len(statsFile) > 0 {
+				if err := report.WriteJSON(statsFile); err != nil {
+					log.Warning("Error while writing statistics: %s", err)
+				} else {
+					log.Info("Saved statistics to %s", statsFile)
+				}
+			}
+		})
+	}
+
+	slock.Lock()
+	nzbinfo := make(map[string]NzbFile, 0)
+	segs := make(map[string][]NzbSegment, 0)
+	slock.Unlock()
+
+	// Make a channel to stuff TimeDatas into
+	tdchan := make(chan *TimeData, 100000)
+
 	// Iterate over configured servers
 	for name, server := range serverList {
+		name, server := name, server
 		log.Info("[%s] Starting %d connections", name, server.Connections)
 
 		// Make a channel to stuff Articles into
 		achan := make(chan *Article, server.Connections)
-
-		// Make a channel to stuff Totals into
-		tchan := make(chan *Totals, server.Connections)
+		metricQueueDepth.SetFunc(func() float64 { return float64(len(achan)) }, name)
 
 		// Start a goroutine to generate articles
 		wg.Add(1)
-		go func(c chan *Article, files []FileData) {
+		go func(c chan *Article) {
 			defer wg.Done()
 
 			log.Debug("[%s] Article generator started", name)
-
-			mc := NewMmapCache()
-			for filenum, fd := range files {
-				// Open and mmap the file
-				md, err := mc.MapFile(fd.path, len(serverList))
-				if err != nil {
-					log.Fatalf("MapFile error: %s", err)
-				}
-
-				// Work out how many parts we need
-				parts := fd.size / Config.Global.ArticleSize
-				rem := fd.size % Config.Global.ArticleSize
-				if rem > 0 {
-					parts++
-				}
-
-				// Build some articles
-				for partnum := int64(0); partnum < parts; partnum++ {
-					start := partnum * Config.Global.ArticleSize
-					end := min((partnum+1)*Config.Global.ArticleSize, fd.size)
-					ad := &ArticleData{
-						PartNum:   partnum + 1,
-						PartTotal: parts,
-						PartSize:  end - start,
-						PartBegin: start,
-						PartEnd:   end,
-						FileNum:   filenum + 1,
-						FileTotal: len(files),
-						FileSize:  fd.size,
-						FileName:  filepath.Base(fd.path),
-					}
-					var subject string
-					if *dirSubjectFlag {
-						subject = filepath.Base(filepath.Dir(fd.path))
-					} else {
-						subject = *subjectFlag
-					}
-					if len(altnzbpath) == 0 {
-						altnzbpath = SafeFileName(subject)
-					}
-					a := NewArticle(md.data[start:end], ad, subject)
-					c <- a
-				}
-
-				if md.Decrement() {
-					err = mc.CloseFile(fd.path)
-					if err != nil {
-						log.Fatalf("CloseFile error: %s", err)
-					}
-					log.Debug("[%s] Closed file %s", name, fd.path)
-				}
-			}
-
-			close(c)
-		}(achan, files)
+			source(name, c)
+		}(achan)
 
 		// Start a goroutine for each individual connection
 		for i := 0; i < server.Connections; i++ {
@@ -146,93 +382,77 @@ func Spawner(filenames []string) {
 
 			// Increment the WaitGroup counters
 			wg.Add(1)
-			go func(achan chan *Article, tchan chan *Totals) {
+			go func(achan chan *Article) {
 				// Decrement the counter when the goroutine completes
 				defer wg.Done()
 
-				// Connect
-				log.Debug("[%s:%02d] Connecting...", name, connID)
-				conn, err := simplenntp.Dial(server.Address, server.Port, server.TLS, server.InsecureSSL, tdchan)
-				if err != nil {
-					log.Fatalf("[%s] Error while connecting: %s", name, err)
-				}
-				log.Debug("[%s:%02d] Connected", name, connID)
-
-				// Authenticate if required
-				if len(server.Username) > 0 {
-					log.Debug("[%s:%02d] Authenticating...", name, connID)
-					err := conn.Authenticate(server.Username, server.Password)
-					if err != nil {
-						log.Fatalf("[%s:%02d] Error while authenticating: %s", name, connID, err)
-					}
-					log.Debug("[%s:%02d] Authenticated", name, connID)
+				// Connecting counts as part of posting the first article, so
+				// failing to connect is retried like any other post error
+				var conn poster
+				dial := func() (poster, error) {
+					return newPoster(name, connID, server, tdchan)
 				}
 
-				t := &Totals{start: time.Now()}
+				cs := report.NewConn(name, connID)
 
 				// Begin consuming
 				for article := range achan {
-					err := conn.Post(article.Body, Config.Global.ChunkSize)
+					// Servers drop connections that sat idle for too long
+					if c, ok := conn.(*simplenntp.Conn); ok && c.IdleExpired() {
+						log.Debug("[%s:%02d] Connection idle for %s, reconnecting", name, connID, c.Idle().Round(time.Second))
+						closePoster(conn, name)
+						conn = nil
+					}
+
+					var err error
+					conn, err = postRetrying(conn, dial, name, connID, article, cs)
 					if err != nil {
-						log.Fatalf("[%s:%02d] Post error: %s", name, connID, err)
+						progress.ArticleFailed(name, article.FileName)
+						cs.Close()
+						saveReport()
+						fatalf("[%s:%02d] Post error: %s", name, connID, err)
 					} else {
+						cs.Posted(int64(len(article.Body)))
+						progress.ArticleDone(name, article.FileName, article.Segment.Bytes)
 						slock.Lock()
 						nzbinfo[article.FileName] = article.NzbData
 						segs[article.FileName] = append(segs[article.FileName], article.Segment)
 						slock.Unlock()
 					}
-					t.bytes += int64(len(article.Body))
 				}
-
-				// Stick our totals struct into the channel
-				t.end = time.Now()
-				tchan <- t
+				cs.Close()
 
 				// Close the connection
-				log.Debug("[%s:%02d] Closing connection", name, connID)
-				err = conn.Quit()
-				if err != nil {
-					log.Warning("[%s:%02d] Error while closing connection: %s", name, connID, err)
+				if conn != nil {
+					log.Debug("[%s:%02d] Closing connection", name, connID)
+					if err := closePoster(conn, name); err != nil {
+						log.Warning("[%s:%02d] Error while closing connection: %s", name, connID, err)
+					}
 				}
-			}(achan, tchan)
+			}(achan)
 		}
-		// Start a goroutine to print some stats when done, sigh
-		wg.Add(1)
-		go func(tchan chan *Totals) {
-			defer wg.Done()
-
-			minStart := time.Now()
-			var maxEnd time.Time
-			var totalBytes int64
-
-			for i := 0; i < server.Connections; i++ {
-				t := <-tchan
-				if t.start.Before(minStart) {
-					minStart = t.start
-				}
-				if t.end.After(maxEnd) {
-					maxEnd = t.end
-				}
-				totalBytes += t.bytes
-			}
-
-			// Calculate and log the result
-			dur := maxEnd.Sub(minStart)
-			speed, speedUnit := prettySize(float64(totalBytes) / dur.Seconds())
-			totalMB := float64(totalBytes) / 1024 / 1024
-
-			log.Info("[%s] Posted %.1fMiB in %s at %.1f%s/s", name, totalMB, dur.String(), speed, speedUnit)
-		}(tchan)
 	}
 
 	// Start our weird status goroutine
-	statusTicker := time.NewTicker(time.Second * 1)
-	go StatusLogger(statusTicker, tdchan)
+	stop := make(chan bool)
+	done := make(chan bool)
+	fileHooks := newHookRenderer(renderer)
+	go StatusLogger(tdchan, progress, fileHooks, stop, done)
 
 	// Wait for all connections to complete
 	wg.Wait()
+	close(stop)
+	<-done
+	fileHooks.Wait()
 
-	// Generate Nzb
+	saveReport()
+
+	return nzbinfo, segs
+}
+
+// writeNzb writes nzb to the configured path, or a generated one based on
+// altnzbpath, and returns the path used.
+func writeNzb(nzb *Nzb, altnzbpath string) (string, error) {
 	var nzbpath string
 	if len(*nzbFlag) > 0 {
 		nzbpath = *nzbFlag
@@ -247,12 +467,17 @@ func Spawner(filenames []string) {
 		nzbpath = fmt.Sprintf("gps-%d_%s.nzb", time.Now().Unix(), altnzbpath)
 		log.Info("Using alternative filename: %s", nzbpath)
 	}
-	nzb := Nzb{}
 
-	// Add some metadata
-	if len(*nzbMetaPass) > 0 {
-		nzb.Head = append(nzb.Head, Meta{Type: "password", Value: *nzbMetaPass})
+	if err := CreateNzb(nzbpath, nzb); err != nil {
+		return "", err
 	}
+	log.Info("Generated Nzb file: %s", nzbpath)
+	return nzbpath, nil
+}
+
+// buildNzb turns the collected file information into an Nzb
+func buildNzb(nzbinfo map[string]NzbFile, segs map[string][]NzbSegment, meta []Meta) *Nzb {
+	nzb := &Nzb{Head: meta}
 
 	slock.Lock()
 	for i, _ := range nzbinfo {
@@ -267,12 +492,161 @@ func Spawner(filenames []string) {
 	}
 	slock.Unlock()
 
-	err := CreateNzb(nzbpath, &nzb)
+	return nzb
+}
+
+// newPoster returns something to post articles to for one connection
+func newPoster(name string, connID int, server *ConfigServer, tdchan chan *TimeData) (poster, error) {
+	var conn poster
+	if offline() {
+		dir := *dryRunDirFlag
+		if len(*spoolFlag) > 0 {
+			dir = *spoolFlag
+		}
+		dc, err := NewDryConn(dir, tdchan)
+		if err != nil {
+			return nil, fmt.Errorf("Offline error: %s", err)
+		}
+		conn = dc
+	} else {
+		c, err := connect(name, connID, server, tdchan)
+		if err != nil {
+			return nil, err
+		}
+		conn = c
+	}
+	metricConnections.Inc(name)
+	return conn, nil
+}
+
+// How long a connection that is being closed gets to answer QUIT
+const quitTimeout = 5 * time.Second
+
+// closePoster closes a connection made by newPoster without waiting long for
+// one that may well be broken.
+func closePoster(conn poster, name string) error {
+	metricConnections.Add(-1, name)
+	if c, ok := conn.(*simplenntp.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), quitTimeout)
+		defer cancel()
+		return c.QuitContext(ctx)
+	}
+	return conn.Quit()
+}
+
+// postRetrying posts article on conn, dialing a new connection first if conn
+// is nil. Failed attempts, including failing to connect, are retried on a
+// new connection up to PostRetries times. It returns the connection to use
+// for the next article, nil if there is none, and the error of the last
+// attempt.
+func postRetrying(conn poster, dial func() (poster, error), name string, connID int, article *Article, cs *ConnStats) (poster, error) {
+	attempt := func() error {
+		if conn == nil {
+			c, err := dial()
+			if err != nil {
+				return err
+			}
+			conn = c
+		}
+		return postArticle(conn, name, article)
+	}
+
+	err := attempt()
+	// A broken article won't get any better by trying again
+	_, broken := err.(simplenntp.HeaderError)
+	for retry := 1; err != nil && !broken && retry <= Config.Global.PostRetries; retry++ {
+		cs.Error(err, true)
+		metricArticlesRetried.Inc(name)
+		log.Warning("[%s:%02d] Post error, reconnecting for retry %d/%d: %s", name, connID, retry, Config.Global.PostRetries, err)
+
+		if conn != nil {
+			closePoster(conn, name)
+			conn = nil
+		}
+		err = attempt()
+		_, broken = err.(simplenntp.HeaderError)
+	}
 	if err != nil {
-		log.Warning("Error while creating Nzb: %s", err)
+		cs.Error(err, false)
+		metricArticlesFailed.Inc(name)
+		// Start the next article on a fresh connection
+		if !broken && conn != nil {
+			closePoster(conn, name)
+			conn = nil
+		}
 	}
-	log.Info("Generated Nzb file: %s", nzbpath)
-	statusTicker.Stop()
+	return conn, err
+}
+
+// postArticle posts a single article and records metrics about it
+func postArticle(conn poster, name string, article *Article) error {
+	start := time.Now()
+	err := conn.Post(article.Body, Config.Global.ChunkSize)
+	metricPostDuration.Observe(time.Since(start).Seconds(), name)
+
+	if err == nil {
+		metricResponses.Inc(name, "240")
+		metricArticlesPosted.Inc(name)
+		metricPostedBytes.Add(float64(len(article.Body)), name)
+	} else if _, ok := err.(simplenntp.Error); ok {
+		metricResponses.Inc(name, errorCode(err))
+	}
+	return err
+}
+
+// connect dials and authenticates a single connection to server
+func connect(name string, connID int, server *ConfigServer, tdchan chan *TimeData) (*simplenntp.Conn, error) {
+	ctx, cancel := connectContext(server)
+	defer cancel()
+
+	// Connect
+	log.Debug("[%s:%02d] Connecting...", name, connID)
+	conn, err := dialServer(ctx, server, connID, tdchan)
+	if err != nil {
+		return nil, fmt.Errorf("Error while connecting: %w", err)
+	}
+	log.Debug("[%s:%02d] Connected", name, connID)
+	if mode, _ := server.TLSMode(); mode == simplenntp.OptionalStartTLS && !conn.TLS {
+		log.Warning("[%s:%02d] Server doesn't offer STARTTLS, the connection is not encrypted", name, connID)
+	}
+
+	// Authenticate if required
+	log.Debug("[%s:%02d] Authenticating...", name, connID)
+	if err := authenticate(ctx, conn, server); err != nil {
+		conn.QuitContext(ctx)
+		return nil, fmt.Errorf("Error while authenticating: %w", err)
+	}
+	log.Debug("[%s:%02d] Authenticated", name, connID)
+
+	if server.Compress {
+		err := conn.CompressContext(ctx)
+		_, refused := err.(simplenntp.Error)
+		switch {
+		case err == nil:
+			log.Debug("[%s:%02d] Compression enabled", name, connID)
+		case refused:
+			log.Warning("[%s:%02d] Compression not available: %s", name, connID, err)
+		default:
+			conn.QuitContext(ctx)
+			return nil, fmt.Errorf("Error while enabling compression: %w", err)
+		}
+	}
+
+	// Servers that greet with 201 often allow posting once logged in
+	if !conn.PostingAllowed {
+		caps, err := conn.CapabilitiesContext(ctx)
+		// Plenty of older servers don't do CAPABILITIES at all
+		if _, ok := err.(simplenntp.Error); err != nil && !ok {
+			conn.QuitContext(ctx)
+			return nil, fmt.Errorf("Error while reading capabilities: %w", err)
+		}
+		if err == nil && !caps.Post {
+			conn.QuitContext(ctx)
+			return nil, fmt.Errorf("Server does not allow posting")
+		}
+	}
+
+	return conn, nil
 }
 
 // math.Min wants float64s, zzz
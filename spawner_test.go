@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+)
+
+// fakePoster fails the first fail posts
+type fakePoster struct {
+	fail   int
+	err    error
+	posts  int
+	closed bool
+}
+
+func (f *fakePoster) Post(p []byte, chunkSize int64) error {
+	f.posts++
+	if f.posts <= f.fail {
+		return f.err
+	}
+	return nil
+}
+
+func (f *fakePoster) Quit() error {
+	f.closed = true
+	return nil
+}
+
+func TestPostRetries(t *testing.T) {
+	defer func(g ConfigGlobal) { Config.Global = g }(Config.Global)
+	Config.Global.PostRetries = 2
+	article := &Article{Body: []byte("article\r\n")}
+	dialErr := fmt.Errorf("connection refused")
+	postErr := simplenntp.Error{Code: simplenntp.StatusPostingFailed, Msg: "posting failed"}
+
+	tests := []struct {
+		name string
+		// What every dial returns, nil for a poster that works
+		dials []error
+		// Errors of the posters in order
+		posts            []error
+		dialed, attempts int
+		retries, failed  int64
+		ok               bool
+	}{
+		{"first try", nil, nil, 1, 1, 0, 0, true},
+		{"post retried", nil, []error{postErr, nil}, 2, 2, 1, 0, true},
+		{"dial retried", []error{dialErr, dialErr, nil}, nil, 3, 1, 2, 0, true},
+		{"mixed", []error{nil, dialErr, nil}, []error{postErr, postErr}, 3, 2, 2, 1, false},
+		{"dial gives up", []error{dialErr, dialErr, dialErr, nil}, nil, 3, 0, 2, 1, false},
+		{"broken article", nil, []error{simplenntp.HeaderError{Line: 1, Reason: "bad"}}, 1, 1, 0, 1, false},
+	}
+	for _, test := range tests {
+		var posters []*fakePoster
+		dialed := 0
+		dial := func() (poster, error) {
+			dialed++
+			if dialed <= len(test.dials) && test.dials[dialed-1] != nil {
+				return nil, test.dials[dialed-1]
+			}
+			p := &fakePoster{}
+			if len(posters) < len(test.posts) && test.posts[len(posters)] != nil {
+				p.fail, p.err = 1, test.posts[len(posters)]
+			}
+			posters = append(posters, p)
+			return p, nil
+		}
+
+		cs := NewStatsReport().NewConn("test", 1)
+		conn, err := postRetrying(nil, dial, "test", 1, article, cs)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: expected ok=%v, got %v", test.name, test.ok, err)
+		}
+		attempts := 0
+		for _, p := range posters {
+			attempts += p.posts
+		}
+		if dialed != test.dialed || attempts != test.attempts {
+			t.Errorf("%s: dialed %d times with %d posts, want %d and %d", test.name, dialed, attempts, test.dialed, test.attempts)
+		}
+		if cs.Retries != test.retries || cs.Failed != test.failed {
+			t.Errorf("%s: got %d retries and %d failures, want %d and %d", test.name, cs.Retries, cs.Failed, test.retries, test.failed)
+		}
+		// Every connection but the one handed back is closed
+		for i, p := range posters {
+			if p.closed == (poster(p) == conn) {
+				t.Errorf("%s: connection %d closed=%v", test.name, i, p.closed)
+			}
+		}
+	}
+
+	// An existing connection is used first
+	existing := &fakePoster{}
+	conn, err := postRetrying(existing, nil, "test", 1, article, NewStatsReport().NewConn("test", 1))
+	if err != nil || conn != poster(existing) || existing.posts != 1 {
+		t.Errorf("existing connection not used: %v %v", conn, err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{simplenntp.Error{Code: simplenntp.StatusPostingFailed}, "441"},
+		{fmt.Errorf("Error while authenticating: %w", simplenntp.Error{Code: 481}), "481"},
+		{simplenntp.ProtocolError("garbage"), "protocol"},
+		{simplenntp.HeaderError{Line: 2, Reason: "bad"}, "article"},
+		{fmt.Errorf("connection reset"), "network"},
+	}
+	for _, test := range tests {
+		if got := errorCode(test.err); got != test.want {
+			t.Errorf("%v: got %s, want %s", test.err, got, test.want)
+		}
+	}
+}
+
+func TestSelectServersOffline(t *testing.T) {
+	defer func(s map[string]*ConfigServer, dryRun bool) { Config.Server, *dryRunFlag = s, dryRun }(Config.Server, *dryRunFlag)
+	Config.Server = map[string]*ConfigServer{"one": {Connections: 4}, "two": {Connections: 8}}
+
+	*dryRunFlag = false
+	if servers := selectServers(); len(servers) != 2 {
+		t.Errorf("expected both servers, got %v", servers)
+	}
+	*dryRunFlag = true
+	servers := selectServers()
+	if s, ok := servers["offline"]; len(servers) != 1 || !ok || s.Connections != 1 {
+		t.Errorf("expected a single offline server, got %v", servers)
+	}
+}
+
+func TestWriteNzbError(t *testing.T) {
+	defer func(f string) { *nzbFlag = f }(*nzbFlag)
+	*nzbFlag = filepath.Join(os.TempDir(), "gps-missing-dir", "x", "test.nzb")
+	if path, err := writeNzb(&Nzb{}, "alt"); err == nil {
+		os.Remove(path)
+		t.Errorf("expected an error, got %s", path)
+	}
+}
+
+// TestConnectTimeout checks that the connect timeout also covers the
+// commands sent after logging in
+func TestConnectTimeout(t *testing.T) {
+	// Never answers COMPRESS
+	l, _ := authServer(t, map[string]string{"COMPRESS DEFLATE": ""})
+	defer l.Close()
+	server := &ConfigServer{Address: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port, Compress: true, ConnectTimeout: 1}
+
+	start := time.Now()
+	if _, err := connect("slow", 1, server, nil); err == nil || !strings.Contains(err.Error(), "compression") {
+		t.Errorf("expected a compression error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("connect took %s", elapsed)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+)
+
+// SplitFiles presents every file larger than size as a set of numbered
+// volumes (.001, .002, ...). Volumes are just byte ranges of the original
+// file, nothing is copied to disk.
+func SplitFiles(files []FileData, size int64) []FileData {
+	if size <= 0 {
+		return files
+	}
+
+	out := make([]FileData, 0, len(files))
+	for _, fd := range files {
+		if fd.size <= size {
+			out = append(out, fd)
+			continue
+		}
+
+		volumes := fd.size / size
+		if fd.size%size > 0 {
+			volumes++
+		}
+
+		// Always use at least 3 digits, more if we need them
+		digits := len(fmt.Sprintf("%d", volumes))
+		if digits < 3 {
+			digits = 3
+		}
+
+		for v := int64(0); v < volumes; v++ {
+			start := v * size
+			end := min((v+1)*size, fd.size)
+			out = append(out, FileData{
+				path:    fd.path,
+				name:    fmt.Sprintf("%s.%0*d", fd.name, digits, v+1),
+				offset:  fd.offset + start,
+				size:    end - start,
+				subject: fd.subject,
+				input:   fd.input,
+				sources: fd.sources,
+			})
+		}
+		log.Debug("Split %s into %d volume(s)", fd.path, volumes)
+	}
+	return out
+}
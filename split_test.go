@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSplitFiles(t *testing.T) {
+	tests := []struct {
+		size, split int64
+		volumes     []int64
+	}{
+		{10, 0, []int64{10}},
+		{10, 10, []int64{10}},
+		{10, 11, []int64{10}},
+		{10, 9, []int64{9, 1}},
+		{10, 5, []int64{5, 5}},
+		{10, 3, []int64{3, 3, 3, 1}},
+		{1, 1, []int64{1}},
+	}
+	for _, test := range tests {
+		in := FileData{path: "/in/file.bin", name: "file.bin", offset: 100, size: test.size, subject: "subj", input: "/in", sources: []string{"a.bin"}}
+		out := SplitFiles([]FileData{in}, test.split)
+		if len(out) != len(test.volumes) {
+			t.Errorf("%d/%d: expected %d volume(s), got %d", test.size, test.split, len(test.volumes), len(out))
+			continue
+		}
+		if len(out) == 1 {
+			if !reflect.DeepEqual(out[0], in) {
+				t.Errorf("%d/%d: file changed to %+v", test.size, test.split, out[0])
+			}
+			continue
+		}
+
+		offset := in.offset
+		for i, fd := range out {
+			name := fmt.Sprintf("file.bin.%03d", i+1)
+			if fd.name != name || fd.offset != offset || fd.size != test.volumes[i] {
+				t.Errorf("%d/%d: volume %d is %s at %d+%d, want %s at %d+%d", test.size, test.split, i,
+					fd.name, fd.offset, fd.size, name, offset, test.volumes[i])
+			}
+			if fd.path != in.path || fd.subject != in.subject || fd.input != in.input || !reflect.DeepEqual(fd.sources, in.sources) {
+				t.Errorf("%d/%d: volume %d lost its origin: %+v", test.size, test.split, i, fd)
+			}
+			offset += fd.size
+		}
+		if offset != in.offset+in.size {
+			t.Errorf("%d/%d: volumes end at %d, want %d", test.size, test.split, offset, in.offset+in.size)
+		}
+	}
+
+	// More than 999 volumes need more digits
+	out := SplitFiles([]FileData{{name: "big", size: 1001}}, 1)
+	if len(out) != 1001 || out[0].name != "big.0001" || out[1000].name != "big.1001" {
+		t.Errorf("unexpected volume names %s ... %s", out[0].name, out[len(out)-1].name)
+	}
+
+	// Small files are left alone
+	out = SplitFiles([]FileData{{name: "a", size: 2}, {name: "b", size: 4}}, 2)
+	if len(out) != 3 || out[0].name != "a" || out[1].name != "b.001" || out[2].name != "b.002" {
+		t.Errorf("unexpected files %+v", out)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the manifest in a spool directory. It is a regular Nzb describing
+// every article in the spool.
+const spoolManifest = "manifest.nzb"
+
+// Meta in the manifest holding the subject of the job, the Nzb only has the
+// subjects of the articles
+const spoolSubjectMeta = "x-gps-subject"
+
+// spoolPath returns the path of the article with the given Message-ID
+func spoolPath(dir, msgid string) string {
+	return filepath.Join(dir, strings.Replace(msgid, "/", "_", -1)+".eml")
+}
+
+// WriteSpoolManifest writes the manifest for a spool of generated articles
+// posted as subject
+func WriteSpoolManifest(dir string, nzb *Nzb, subject string) error {
+	path := filepath.Join(dir, spoolManifest)
+	manifest := *nzb
+	manifest.Head = append(append([]Meta{}, nzb.Head...), Meta{Type: spoolSubjectMeta, Value: subject})
+	if err := CreateNzb(path, &manifest); err != nil {
+		return err
+	}
+	log.Info("Generated spool manifest: %s", path)
+	return nil
+}
+
+// ReadSpoolManifest reads the manifest of a spool directory and returns it
+// along with the subject of the job
+func ReadSpoolManifest(dir string) (*Nzb, string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, spoolManifest))
+	if err != nil {
+		return nil, "", err
+	}
+
+	nzb := &Nzb{}
+	if err := xml.Unmarshal(data, nzb); err != nil {
+		return nil, "", err
+	}
+
+	var subject string
+	head := nzb.Head[:0]
+	for _, m := range nzb.Head {
+		if m.Type == spoolSubjectMeta {
+			subject = m.Value
+		} else {
+			head = append(head, m)
+		}
+	}
+	nzb.Head = head
+	return nzb, subject, nil
+}
+
+// SpoolSpawner posts the articles of a spool previously written with -spool
+// and generates an Nzb for them.
+func SpoolSpawner(dir string) {
+	log.Debug("SpoolSpawner started")
+
+	manifest, subject, err := ReadSpoolManifest(dir)
+	if err != nil {
+		fatalf("Spool error: %s", err)
+	}
+
+	progress := NewProgress()
+	var articles int
+	var totalBytes int64
+	for _, file := range manifest.File {
+		articles += len(file.Segments)
+		for _, seg := range file.Segments {
+			progress.AddFile(file.Subject, seg.Bytes)
+			totalBytes += seg.Bytes
+		}
+	}
+	log.Info("Found %d file(s) with %d article(s) in spool %s", len(manifest.File), articles, dir)
+
+	if len(manifest.File) > 0 {
+		hooks.SetJob(subject, len(manifest.File), totalBytes)
+	}
+	hooks.Fire(&HookEvent{Event: HookStart, Status: "running"})
+
+	altnzbpath := SafeFileName(subject)
+
+	// Read articles back from the spool
+	source := func(name string, c chan *Article) {
+		for _, file := range manifest.File {
+			info := file
+			info.Segments = nil
+
+			for _, seg := range file.Segments {
+				body, err := ioutil.ReadFile(spoolPath(dir, seg.MessageId))
+				if err != nil {
+					fatalf("Spool error: %s", err)
+				}
+				c <- &Article{Body: body, NzbData: info, Segment: seg, FileName: file.Subject}
+			}
+		}
+		close(c)
+	}
+
+	serverList := selectServers()
+	groupLists := make([]string, len(manifest.File))
+	for i, file := range manifest.File {
+		groupLists[i] = strings.Join(file.Groups, ",")
+	}
+	checkGroups(serverList, groupLists)
+
+	nzbinfo, segs := postArticles(serverList, source, progress)
+
+	meta := manifest.Head
+	if len(*nzbMetaPass) > 0 {
+		meta = setPassword(meta, *nzbMetaPass)
+	}
+	finishJob(buildNzb(nzbinfo, segs, meta), altnzbpath)
+}
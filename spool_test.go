@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSpoolManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gps-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, _, err := ReadSpoolManifest(dir); err == nil {
+		t.Error("expected an error for a spool without a manifest")
+	}
+
+	nzb := &Nzb{
+		Head: []Meta{{Type: "title", Value: `a "title" & <more>`}, {Type: "password", Value: "secret"}},
+		File: NzbFiles{
+			{
+				Poster:  "poster <poster@example.com>",
+				Date:    1500000000,
+				Subject: `"file.bin" yEnc (1/2)`,
+				Groups:  []string{"alt.binaries.test", "alt.binaries.misc"},
+				Segments: NzbSegments{
+					{Bytes: 700, Number: 2, MessageId: "b/2@example.com"},
+					{Bytes: 1000, Number: 1, MessageId: "a/1@example.com"},
+				},
+			},
+			{
+				Poster:   "poster <poster@example.com>",
+				Date:     1500000001,
+				Subject:  `"file.sfv" yEnc (1/1)`,
+				Groups:   []string{"alt.binaries.test"},
+				Segments: NzbSegments{{Bytes: 50, Number: 1, MessageId: "c@example.com"}},
+			},
+		},
+	}
+	if err := WriteSpoolManifest(dir, nzb, "My files"); err != nil {
+		t.Fatal(err)
+	}
+	if len(nzb.Head) != 2 {
+		t.Errorf("the job subject was added to the Nzb itself: %+v", nzb.Head)
+	}
+
+	got, subject, err := ReadSpoolManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "My files" {
+		t.Errorf("got job subject %q", subject)
+	}
+	if got.File[0].Segments[0].Number != 1 {
+		t.Errorf("segments weren't sorted: %+v", got.File[0].Segments)
+	}
+
+	// Only compare what the manifest carries
+	got.XMLName, nzb.XMLName = xml.Name{}, xml.Name{}
+	got.XMLns = nzb.XMLns
+	for _, n := range []*Nzb{got, nzb} {
+		for i := range n.File {
+			for j := range n.File[i].Segments {
+				n.File[i].Segments[j].XMLName = xml.Name{}
+			}
+		}
+	}
+	if !reflect.DeepEqual(got, nzb) {
+		t.Errorf("manifest came back as\n%+v\nwant\n%+v", got, nzb)
+	}
+
+	if path := spoolPath(dir, "a/1@example.com"); path != filepath.Join(dir, "a_1@example.com.eml") {
+		t.Errorf("unexpected spool path %s", path)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomarus/GoPostStuff/simplenntp"
+)
+
+// StatsReport collects per-server and per-connection statistics for the
+// final report at the end of a run.
+type StatsReport struct {
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Elapsed  float64        `json:"elapsed"`
+	Bytes    int64          `json:"bytes"`
+	Articles int64          `json:"articles"`
+	Failed   int64          `json:"failed"`
+	Retries  int64          `json:"retries"`
+	Servers  []*ServerStats `json:"servers"`
+	sync.Mutex
+}
+
+// ServerStats are the statistics for a single server
+type ServerStats struct {
+	Name        string           `json:"name"`
+	Start       time.Time        `json:"start"`
+	End         time.Time        `json:"end"`
+	Elapsed     float64          `json:"elapsed"`
+	Bytes       int64            `json:"bytes"`
+	Articles    int64            `json:"articles"`
+	Failed      int64            `json:"failed"`
+	Retries     int64            `json:"retries"`
+	AvgSpeed    float64          `json:"avg_speed"`
+	PeakSpeed   float64          `json:"peak_speed"`
+	Errors      map[string]int64 `json:"errors"`
+	Connections []*ConnStats     `json:"connections"`
+	seconds     map[int64]int64
+}
+
+// ConnStats are the statistics for a single connection
+type ConnStats struct {
+	ID        int              `json:"id"`
+	Start     time.Time        `json:"start"`
+	End       time.Time        `json:"end"`
+	Elapsed   float64          `json:"elapsed"`
+	Bytes     int64            `json:"bytes"`
+	Articles  int64            `json:"articles"`
+	Failed    int64            `json:"failed"`
+	Retries   int64            `json:"retries"`
+	AvgSpeed  float64          `json:"avg_speed"`
+	PeakSpeed float64          `json:"peak_speed"`
+	Errors    map[string]int64 `json:"errors"`
+	server    *ServerStats
+	report    *StatsReport
+	seconds   map[int64]int64
+}
+
+func NewStatsReport() *StatsReport {
+	return &StatsReport{Start: time.Now()}
+}
+
+// NewConn starts tracking a connection to server
+func (r *StatsReport) NewConn(server string, id int) *ConnStats {
+	r.Lock()
+	defer r.Unlock()
+
+	var ss *ServerStats
+	for _, s := range r.Servers {
+		if s.Name == server {
+			ss = s
+			break
+		}
+	}
+	if ss == nil {
+		ss = &ServerStats{Name: server, Start: time.Now(), Errors: make(map[string]int64), seconds: make(map[int64]int64)}
+		r.Servers = append(r.Servers, ss)
+	}
+
+	cs := &ConnStats{ID: id, Start: time.Now(), Errors: make(map[string]int64), server: ss, report: r, seconds: make(map[int64]int64)}
+	ss.Connections = append(ss.Connections, cs)
+	return cs
+}
+
+// Posted records a successfully posted article
+func (cs *ConnStats) Posted(bytes int64) {
+	cs.report.Lock()
+	defer cs.report.Unlock()
+
+	now := time.Now().Unix()
+	cs.Bytes += bytes
+	cs.Articles++
+	cs.seconds[now] += bytes
+	cs.server.Bytes += bytes
+	cs.server.Articles++
+	cs.server.seconds[now] += bytes
+}
+
+// Error records an error returned while posting. retry says whether the
+// article will be tried again.
+func (cs *ConnStats) Error(err error, retry bool) {
+	cs.report.Lock()
+	defer cs.report.Unlock()
+
+	code := errorCode(err)
+	cs.Errors[code]++
+	cs.server.Errors[code]++
+	if retry {
+		cs.Retries++
+		cs.server.Retries++
+	} else {
+		cs.Failed++
+		cs.server.Failed++
+	}
+}
+
+// Close marks the connection as done
+func (cs *ConnStats) Close() {
+	cs.report.Lock()
+	cs.End = time.Now()
+	cs.report.Unlock()
+}
+
+// errorCode returns the NNTP response code for err, or what kind of error it was
+func errorCode(err error) string {
+	var nntpErr simplenntp.Error
+	var protoErr simplenntp.ProtocolError
+	var headerErr simplenntp.HeaderError
+	switch {
+	case errors.As(err, &nntpErr):
+		return fmt.Sprintf("%03d", nntpErr.Code)
+	case errors.As(err, &protoErr):
+		return "protocol"
+	case errors.As(err, &headerErr):
+		return "article"
+	}
+	return "network"
+}
+
+// Finish works out the totals and speeds
+func (r *StatsReport) Finish() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.End = time.Now()
+	r.Elapsed = r.End.Sub(r.Start).Seconds()
+
+	sort.Slice(r.Servers, func(i, j int) bool { return r.Servers[i].Name < r.Servers[j].Name })
+	for _, ss := range r.Servers {
+		sort.Slice(ss.Connections, func(i, j int) bool { return ss.Connections[i].ID < ss.Connections[j].ID })
+		for _, cs := range ss.Connections {
+			if cs.End.IsZero() {
+				cs.End = r.End
+			}
+			if cs.End.After(ss.End) {
+				ss.End = cs.End
+			}
+			cs.Elapsed = cs.End.Sub(cs.Start).Seconds()
+			cs.AvgSpeed = speed(cs.Bytes, cs.Elapsed)
+			cs.PeakSpeed = peak(cs.seconds, cs.AvgSpeed)
+		}
+		ss.Elapsed = ss.End.Sub(ss.Start).Seconds()
+		ss.AvgSpeed = speed(ss.Bytes, ss.Elapsed)
+		ss.PeakSpeed = peak(ss.seconds, ss.AvgSpeed)
+
+		r.Bytes += ss.Bytes
+		r.Articles += ss.Articles
+		r.Failed += ss.Failed
+		r.Retries += ss.Retries
+	}
+}
+
+func speed(bytes int64, secs float64) float64 {
+	if secs <= 0 {
+		return 0
+	}
+	return float64(bytes) / secs
+}
+
+// peak returns the most bytes posted within a single second. Short runs
+// never fill a whole second, so it is never less than the average.
+func peak(seconds map[int64]int64, avg float64) float64 {
+	max := avg
+	for _, b := range seconds {
+		if float64(b) > max {
+			max = float64(b)
+		}
+	}
+	return max
+}
+
+// Log writes the report to the console
+func (r *StatsReport) Log() {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, ss := range r.Servers {
+		log.Info("[%s] Posted %s in %s at %s (peak %s), %d article(s), %d retries, %d failed%s",
+			ss.Name, formatBytes(ss.Bytes), formatElapsed(ss.Elapsed), formatSpeed(ss.AvgSpeed),
+			formatSpeed(ss.PeakSpeed), ss.Articles, ss.Retries, ss.Failed, formatErrors(ss.Errors))
+		for _, cs := range ss.Connections {
+			log.Info("[%s:%02d]   %s in %s at %s (peak %s), %d article(s), %d retries, %d failed%s",
+				ss.Name, cs.ID, formatBytes(cs.Bytes), formatElapsed(cs.Elapsed), formatSpeed(cs.AvgSpeed),
+				formatSpeed(cs.PeakSpeed), cs.Articles, cs.Retries, cs.Failed, formatErrors(cs.Errors))
+		}
+	}
+	log.Info("Posted %s in %s, %d article(s), %d retries, %d failed",
+		formatBytes(r.Bytes), formatElapsed(r.Elapsed), r.Articles, r.Retries, r.Failed)
+}
+
+// WriteJSON writes the report to filename as JSON
+func (r *StatsReport) WriteJSON(filename string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+func formatBytes(b int64) string {
+	size, unit := prettySize(float64(b))
+	return fmt.Sprintf("%.1f%s", size, unit)
+}
+
+func formatSpeed(s float64) string {
+	size, unit := prettySize(s)
+	return fmt.Sprintf("%.1f%s/s", size, unit)
+}
+
+func formatElapsed(secs float64) string {
+	return (time.Duration(secs*1000) * time.Millisecond).String()
+}
+
+func formatErrors(errors map[string]int64) string {
+	if len(errors) == 0 {
+		return ""
+	}
+	codes := make([]string, 0, len(errors))
+	for code := range errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%s=%d", code, errors[code])
+	}
+	return ", errors: " + strings.Join(parts, " ")
+}
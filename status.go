@@ -1,19 +1,38 @@
 package main
 
 import (
-	"fmt"
 	"time"
-
-	"github.com/tomarus/gopoststuff/simplenntp"
 )
 
-func StatusLogger(ticker *time.Ticker, tdchan chan *simplenntp.TimeData) {
-	var tds []*simplenntp.TimeData
-	totalPosted := int64(0)
+// TimeData is a point in time at which Bytes were sent, the speed is worked
+// out from the last few seconds of them.
+type TimeData struct {
+	Milliseconds int64
+	Bytes        int
+}
+
+// StatusLogger feeds the current speed into progress and renders it once a
+// second until stop is closed, then renders a final event and closes done.
+func StatusLogger(tdchan chan *TimeData, progress *Progress, renderer ProgressRenderer, stop, done chan bool) {
+	var tds []*TimeData
+	ticker := time.NewTicker(time.Second * 1)
+	defer ticker.Stop()
+
+	renderer.Render(progress.Snapshot("start"))
+
+	for {
+		var t time.Time
+		select {
+		case t = <-ticker.C:
+		case <-stop:
+			renderFilesDone(progress, renderer)
+			renderer.Render(progress.Snapshot("finish"))
+			close(done)
+			return
+		}
 
-	for t := range ticker.C {
 		stamp := t.UnixNano() / 1e6
-		tds = append(tds, &simplenntp.TimeData{stamp, 0})
+		tds = append(tds, &TimeData{Milliseconds: stamp, Bytes: 0})
 
 		// Fetch any new TimeData entries
 		var breakNow bool
@@ -24,7 +43,6 @@ func StatusLogger(ticker *time.Ticker, tdchan chan *simplenntp.TimeData) {
 			case td := <-tdchan:
 				// New item, add it to our list
 				tds = append(tds, td)
-				totalPosted += int64(td.Bytes)
 			default:
 				// Nothing else in the channel, done for now
 				breakNow = true
@@ -37,27 +55,20 @@ func StatusLogger(ticker *time.Ticker, tdchan chan *simplenntp.TimeData) {
 
 		// Calculate current speed
 		if len(tds) > 0 {
-
 			active := float64(tds[len(tds)-1].Milliseconds-tds[0].Milliseconds) / 1000
 			totalBytes := 0
 			for _, td := range tds {
 				totalBytes += td.Bytes
 			}
-
-			//speed := float64(totalBytes) / float64(active)
-			//speed := float64(totalBytes) / float64(active) / 1024
-			speed, speedUnit := prettySize(float64(totalBytes) / float64(active))
-
-			// Total posted
-			posted := float64(totalPosted) / 1024 / 1024
-
-			// Print it
-			//fmt.Printf("Posted \033[1m%.1f\033[0mMiB - Current speed: \033[1m%.1f\033[0mKiB/s             \r", posted, speed)
-			fmt.Printf("Posted \033[1m%.1f\033[0mMiB - Current speed: \033[1m%.1f\033[0m%s/s             \r", posted, speed, speedUnit)
-
-			//log.Debug("Current speed: %.1fKB/s", speed)
+			if active > 0 {
+				progress.SetSpeed(float64(totalBytes) / active)
+			}
 		}
 
+		// Report finished files, then the overall progress
+		renderFilesDone(progress, renderer)
+		renderer.Render(progress.Snapshot("progress"))
+
 		// Trim slice to only use the last 5 seconds
 		earliest := stamp - 5000
 		start := 0
@@ -72,21 +83,25 @@ func StatusLogger(ticker *time.Ticker, tdchan chan *simplenntp.TimeData) {
 	}
 }
 
+// renderFilesDone renders a file_done event for every file finished since the last call
+func renderFilesDone(progress *Progress, renderer ProgressRenderer) {
+	for _, fp := range progress.FilesDone() {
+		e := progress.Snapshot("file_done")
+		e.File = fp
+		renderer.Render(e)
+	}
+}
+
+// prettySize scales b down to the largest unit it fills at least one of
 func prettySize(b float64) (nb float64, nu string) {
-	units := make(map[string]float64)
-	units["B"] = 0
-	units["KB"] = 1024
-	units["MB"] = 1024 * 1024
-	units["GB"] = 1024 * 1024 * 1024
-	units["TB"] = 1024 * 1024 * 1024 * 1024
-
-	for k, v := range units {
-		tspeed := b / v
-		if tspeed >= 10.0 && tspeed < 100.0 {
-			nb = tspeed
-			nu = k
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+
+	nb = b
+	for _, nu = range units {
+		if nb < 1024 || nu == units[len(units)-1] {
 			break
 		}
+		nb /= 1024
 	}
 	return nb, nu
 }
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestPrettySize(t *testing.T) {
+	tests := []struct {
+		b    float64
+		size float64
+		unit string
+	}{
+		{0, 0, "B"},
+		{5, 5, "B"},
+		{1023, 1023, "B"},
+		{1024, 1, "KB"},
+		{1536, 1.5, "KB"},
+		{99 * 1024 * 1024, 99, "MB"},
+		{1024 * 1024 * 1024, 1, "GB"},
+		{3 * 1024 * 1024 * 1024 * 1024, 3, "TB"},
+		{2048 * 1024 * 1024 * 1024 * 1024, 2048, "TB"},
+	}
+	for _, test := range tests {
+		if size, unit := prettySize(test.b); size != test.size || unit != test.unit {
+			t.Errorf("%v: got %v %s, want %v %s", test.b, size, unit, test.size, test.unit)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NzbUploader pushes a generated Nzb to an indexer, HTTP endpoint and/or
+// a local blackhole directory.
+type NzbUploader struct {
+	cfg    ConfigUpload
+	client *http.Client
+}
+
+func NewNzbUploader(cfg ConfigUpload) *NzbUploader {
+	// 0 is the default, -1 turns retrying off
+	if cfg.Retries == 0 {
+		cfg.Retries = 3
+	} else if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.RetryWait <= 0 {
+		cfg.RetryWait = 10
+	}
+	if len(cfg.Mode) == 0 {
+		cfg.Mode = "post"
+	}
+	cfg.Mode = strings.ToLower(cfg.Mode)
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	return &NzbUploader{cfg: cfg, client: &http.Client{Timeout: timeout}}
+}
+
+// Upload sends nzbpath everywhere that is configured. Problems are logged,
+// the Nzb has been written already so they don't fail the job.
+func (u *NzbUploader) Upload(nzbpath string) {
+	if len(u.cfg.Blackhole) > 0 {
+		dest, err := u.blackhole(nzbpath)
+		if err != nil {
+			log.Warning("Error while copying Nzb to blackhole: %s", err)
+		} else {
+			log.Info("Copied Nzb to blackhole: %s", dest)
+		}
+	}
+
+	if len(u.cfg.URL) == 0 {
+		return
+	}
+
+	data, err := ioutil.ReadFile(nzbpath)
+	if err != nil {
+		log.Warning("Error while reading Nzb for upload: %s", err)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		status, err := u.send(filepath.Base(nzbpath), data)
+		if err == nil {
+			log.Info("Uploaded Nzb to %s: %s", u.cfg.URL, status)
+			return
+		}
+		if attempt > u.cfg.Retries {
+			log.Warning("Giving up on Nzb upload after %d attempt(s): %s", attempt, err)
+			return
+		}
+		log.Warning("Nzb upload attempt %d failed, retrying in %ds: %s", attempt, u.cfg.RetryWait, err)
+		time.Sleep(time.Duration(u.cfg.RetryWait) * time.Second)
+	}
+}
+
+// send makes a single upload request and returns the response status
+func (u *NzbUploader) send(name string, data []byte) (string, error) {
+	var req *http.Request
+	var err error
+
+	switch u.cfg.Mode {
+	case "newznab":
+		req, err = u.newznabRequest(name, data)
+	case "post", "put":
+		req, err = http.NewRequest(strings.ToUpper(u.cfg.Mode), u.cfg.URL, bytes.NewReader(data))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-nzb")
+		}
+	default:
+		return "", fmt.Errorf("Unknown upload mode '%s'", u.cfg.Mode)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("User-Agent", "GoPostStuff/"+GPS_VERSION)
+	for _, h := range u.cfg.Header {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("Invalid upload header '%s'", h)
+		}
+		req.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	// newznab style APIs report errors with a 200 and an <error> element
+	if u.cfg.Mode == "newznab" && bytes.Contains(body, []byte("<error")) {
+		return "", fmt.Errorf("%s", strings.TrimSpace(string(body)))
+	}
+	return resp.Status, nil
+}
+
+// newznabRequest builds a multipart t=nzbadd style API request
+func (u *NzbUploader) newznabRequest(name string, data []byte) (*http.Request, error) {
+	target, err := url.Parse(u.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := target.Query()
+	if len(q.Get("t")) == 0 {
+		q.Set("t", "nzbadd")
+	}
+	if len(u.cfg.APIKey) > 0 {
+		q.Set("apikey", u.cfg.APIKey)
+	}
+	target.RawQuery = q.Encode()
+
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		return nil, err
+	}
+	fw.Write(data)
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", target.String(), buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req, nil
+}
+
+// blackhole copies nzbpath into the blackhole directory. It is written under
+// a temporary name first so nothing watching the directory sees half a file.
+func (u *NzbUploader) blackhole(nzbpath string) (string, error) {
+	data, err := ioutil.ReadFile(nzbpath)
+	if err != nil {
+		return "", err
+	}
+
+	dest := filepath.Join(u.cfg.Blackhole, filepath.Base(nzbpath))
+	tmp := filepath.Join(u.cfg.Blackhole, "."+filepath.Base(nzbpath)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dest, nil
+}
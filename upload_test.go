@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestNzb(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "test.nzb")
+	if err := ioutil.WriteFile(path, []byte("<nzb></nzb>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUploadNewznab(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gps-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	nzbpath := writeTestNzb(t, dir)
+
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Query().Get("t") != "nzbadd" || r.URL.Query().Get("apikey") != "secret" {
+			t.Errorf("unexpected query: %s", r.URL.RawQuery)
+		}
+		if r.Header.Get("X-Test") != "yes" {
+			t.Errorf("missing custom header")
+		}
+		f, fh, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("no file in upload: %s", err)
+			return
+		}
+		data, _ := ioutil.ReadAll(f)
+		if fh.Filename != "test.nzb" || string(data) != "<nzb></nzb>" {
+			t.Errorf("unexpected upload %s: %q", fh.Filename, data)
+		}
+	}))
+	defer ts.Close()
+
+	u := NewNzbUploader(ConfigUpload{Mode: "newznab", URL: ts.URL + "/api", APIKey: "secret", Header: []string{"X-Test: yes"}, Retries: 2})
+	// Don't make the test wait between attempts
+	u.cfg.RetryWait = 0
+	u.Upload(nzbpath)
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestUploadPutAndBlackhole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gps-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	nzbpath := writeTestNzb(t, dir)
+	hole := filepath.Join(dir, "hole")
+	os.Mkdir(hole, 0755)
+
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	NewNzbUploader(ConfigUpload{Mode: "put", URL: ts.URL, Blackhole: hole}).Upload(nzbpath)
+	if string(body) != "<nzb></nzb>" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+	if _, err := os.Stat(filepath.Join(hole, "test.nzb")); err != nil {
+		t.Fatalf("nzb not in blackhole: %s", err)
+	}
+}
+
+func TestUploadNoRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gps-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	nzbpath := writeTestNzb(t, dir)
+
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct{ retries, attempts int }{{-1, 1}, {0, 4}, {1, 2}} {
+		attempts = 0
+		u := NewNzbUploader(ConfigUpload{URL: ts.URL, Retries: test.retries})
+		u.cfg.RetryWait = 0
+		u.Upload(nzbpath)
+		if attempts != test.attempts {
+			t.Errorf("Retries=%d: expected %d attempts, got %d", test.retries, test.attempts, attempts)
+		}
+	}
+}